@@ -2,6 +2,9 @@ module github.com/0xnu/s3interact
 
 go 1.20
 
-require github.com/aws/aws-sdk-go v1.44.325
+require (
+	github.com/aws/aws-sdk-go v1.44.325
+	golang.org/x/crypto v0.33.0
+)
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
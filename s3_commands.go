@@ -0,0 +1,863 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes returned by run.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// usageError marks an error caused by bad arguments rather than a failed
+// S3 request, so that run can exit with exitUsage.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// options holds the global flags shared by the interactive menu and the
+// subcommands.
+type options struct {
+	session    sessionOptions
+	transfer   transferOptions
+	encryption encryptionOptions
+	dryRun     bool
+	yes        bool
+	protected  protectedList
+}
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(a *app, args []string) error
+}
+
+var commands = []*command{
+	{"ls", "ls [s3://bucket[/prefix]]", "List buckets and objects, or the objects under a prefix", lsCommand},
+	{"mb", "mb [-object-lock] [-ownership mode] [-acl acl] [-encryption algorithm [-kms-key-id key] [-bucket-key]] [-versioning] [-block-public-access] s3://bucket", "Create a bucket in the selected region", mbCommand},
+	{"rb", "rb [-force] s3://bucket", "Delete a bucket, emptying it first with -force", rbCommand},
+	{"mkdir", "mkdir s3://bucket/folder", "Create a folder", mkdirCommand},
+	{"cp", "cp [-version-id id] [-r [-include pattern] [-exclude pattern] [-follow-symlinks] [-on-conflict policy]] <source> <destination>", "Upload, download or copy a file, or upload or download a folder with -r", cpCommand},
+	{"mv", "mv [-r] s3://bucket/source s3://bucket/destination", "Move or rename a file, or a folder with -r", mvCommand},
+	{"rm", "rm [-r] s3://bucket/key...", "Delete files, or folders with -r", rmCommand},
+	{"sync", "sync [-delete] [-checksum] [-dry-run] <source> <destination>", "Copy new and changed files between a local folder and a prefix, or two prefixes", syncCommand},
+	{"versioning", "versioning status|enable|suspend s3://bucket", "Show, enable or suspend bucket versioning", versioningCommand},
+	{"versions", "versions s3://bucket[/key-or-prefix]", "List the versions and delete markers of a key, or of a prefix ending in /", versionsCommand},
+	{"restore", "restore -version-id id s3://bucket/key | restore -at time [-dry-run] s3://bucket/key-or-prefix", "Make a prior version of an object the latest again, or roll a key or prefix back to a point in time", restoreCommand},
+	{"undelete", "undelete s3://bucket/key-or-prefix", "Bring back deleted objects by removing their delete markers", undeleteCommand},
+	{"presign", "presign [-expires minutes] [-shorten] s3://bucket/key", "Generate a pre-signed URL for an object", presignCommand},
+	{"encryption", "encryption get s3://bucket | encryption set [-kms-key-id key] [-bucket-key] s3://bucket AES256|aws:kms | encryption delete s3://bucket", "Show, set or delete the default encryption of a bucket", encryptionCommand},
+	{"lifecycle", "lifecycle get s3://bucket [<rules.json>|-] | lifecycle put s3://bucket <rules.json> | lifecycle delete s3://bucket", "Show, save, set or delete the lifecycle rules of a bucket", lifecycleCommand},
+	{"policy", "policy set s3://bucket <policy.json> | policy delete s3://bucket", "Set or delete a bucket policy", policyCommand},
+	{"acl", "acl s3://bucket <acl>", "Set a canned bucket ACL", aclCommand},
+	{"info", "info s3://bucket[/key]", "Show bucket or object information", infoCommand},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: s3interact [global flags] <command> [flags] [arguments]")
+	fmt.Fprintln(w, "Run without arguments to start the interactive menu.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+		fmt.Fprintf(w, "  %-8s   s3interact %s\n", "", cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	global.SetOutput(w)
+	global.PrintDefaults()
+}
+
+// run parses the global flags and either starts the interactive menu, when
+// no command is given, or executes a single subcommand. It returns the
+// process exit code.
+func run(args []string) int {
+	opts := &options{}
+	global := flag.NewFlagSet("s3interact", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	opts.session.addFlags(global)
+	opts.transfer.addFlags(global)
+	opts.encryption.addFlags(global)
+	global.BoolVar(&opts.dryRun, "dry-run", false, "list the changes each command would make without making them")
+	global.BoolVar(&opts.yes, "yes", false, "delete without asking for confirmation")
+	global.Var((*patternList)(&opts.protected), "protect", "bucket or bucket/prefix that must never be deleted from (repeatable, also read from $S3INTERACT_PROTECT)")
+	(*patternList)(&opts.protected).Set(os.Getenv("S3INTERACT_PROTECT"))
+
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(os.Stdout, global)
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		printUsage(os.Stderr, global)
+		return exitUsage
+	}
+	if err := opts.transfer.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+	if err := opts.encryption.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+
+	if global.NArg() == 0 {
+		return interactive(opts)
+	}
+
+	if global.Arg(0) == "help" {
+		printUsage(os.Stdout, global)
+		return exitOK
+	}
+
+	cmd := findCommand(global.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", global.Arg(0))
+		printUsage(os.Stderr, global)
+		return exitUsage
+	}
+
+	errorOutput = os.Stderr
+	reader := bufio.NewReader(os.Stdin)
+	sess, err := newSession(opts.session, reader, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating session:", err)
+		return exitError
+	}
+
+	a := newApp(sess, opts, reader)
+	err = a.do(func() error {
+		return cmd.run(a, global.Args()[1:])
+	})
+	if err != nil {
+		var uerr *usageError
+		if errors.As(err, &uerr) {
+			fmt.Fprintln(os.Stderr, "Error:", uerr)
+			fmt.Fprintln(os.Stderr, "Usage: s3interact", cmd.usage)
+			return exitUsage
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// parseFlags parses the flags of a subcommand, reporting problems as usage
+// errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v", err)
+	}
+	return nil
+}
+
+// patternList is a flag.Value that collects a pattern each time the flag is
+// given. Comma-separated values are split into several patterns.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*p = append(*p, pattern)
+		}
+	}
+	return nil
+}
+
+// parseS3URI splits an s3://bucket/key URI into its bucket and key.
+func parseS3URI(uri string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(uri, "s3://") {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	return bucket, key, bucket != ""
+}
+
+func requireS3URI(uri string, needKey bool) (bucket, key string, err error) {
+	bucket, key, ok := parseS3URI(uri)
+	if !ok {
+		return "", "", usagef("%q is not an s3://bucket URI", uri)
+	}
+	if needKey && key == "" {
+		return "", "", usagef("%q does not name an object key", uri)
+	}
+	return bucket, key, nil
+}
+
+func lsCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch fs.NArg() {
+	case 0:
+		listings, err := listBucketsAndObjects(svc)
+		if listings == nil && err != nil {
+			fmt.Fprintln(errorOutput, "Error listing buckets:", err)
+			return err
+		}
+		printBucketListings(listings)
+		return err
+	case 1:
+		bucket, prefix, err := requireS3URI(fs.Arg(0), false)
+		if err != nil {
+			return err
+		}
+		objects, err := listObjects(svc, bucket, prefix)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error listing objects:", err)
+			return err
+		}
+		printObjects(objects)
+		return nil
+	default:
+		return usagef("ls takes at most one argument")
+	}
+}
+
+func mbCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("mb", flag.ContinueOnError)
+	opts := bucketOptions{region: a.svc.region()}
+	opts.addFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("mb takes exactly one bucket")
+	}
+	if err := opts.validate(); err != nil {
+		return usagef("%v", err)
+	}
+	bucket, _, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	return report(createBucket(svc, bucket, opts), "Error creating bucket", "Bucket created successfully.")
+}
+
+func rbCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("rb", flag.ContinueOnError)
+	force := fs.Bool("force", false, "empty the bucket of all object versions and uploads first")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("rb takes exactly one bucket")
+	}
+	bucket, _, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	if err := a.confirmDeleteBucket(bucket, *force); err != nil {
+		return err
+	}
+	if *force {
+		if err := a.emptyBucket(bucket); err != nil {
+			return err
+		}
+	}
+	err = deleteBucket(svc, bucket)
+	return report(err, "Error deleting bucket", "Bucket deleted successfully.")
+}
+
+func mkdirCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("mkdir takes exactly one folder")
+	}
+	bucket, folder, err := requireS3URI(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	err = createFolder(svc, bucket, strings.TrimSuffix(folder, "/"))
+	return report(err, "Error creating folder", "Folder created successfully.")
+}
+
+func cpCommand(a *app, args []string) error {
+	svc := a.client()
+	var dirOpts directoryUploadOptions
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "upload a whole folder")
+	fs.Var((*patternList)(&dirOpts.include), "include", "only upload files matching this pattern (repeatable)")
+	fs.Var((*patternList)(&dirOpts.exclude), "exclude", "skip files and folders matching this pattern (repeatable)")
+	fs.BoolVar(&dirOpts.followSymlinks, "follow-symlinks", false, "follow symbolic links instead of skipping them")
+	onConflict := fs.String("on-conflict", string(conflictOverwrite), "what a folder download does with existing files: skip, overwrite or rename")
+	versionID := fs.String("version-id", "", "download or copy this version of the source object")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usagef("cp takes a source and a destination")
+	}
+
+	source, destination := fs.Arg(0), fs.Arg(1)
+	sourceBucket, sourceKey, sourceIsS3 := parseS3URI(source)
+	destinationBucket, destinationKey, destinationIsS3 := parseS3URI(destination)
+
+	if *versionID != "" && (*recursive || !sourceIsS3) {
+		return usagef("-version-id needs a single s3:// source object")
+	}
+
+	if *recursive {
+		switch {
+		case !sourceIsS3 && destinationIsS3:
+			progress := a.newProgress("Uploaded")
+			res, err := uploadDirectory(svc, destinationBucket, source, destinationKey, dirOpts, a.opts.transfer, progress)
+			progress.stop()
+			printTransferSummary(progress)
+			return reportBatch(res, err, "Error uploading file", "Folder uploaded successfully (%d files).")
+		case sourceIsS3 && !destinationIsS3:
+			conflict, err := parseConflictPolicy(*onConflict)
+			if err != nil {
+				return usagef("%v", err)
+			}
+			progress := a.newProgress("Downloaded")
+			res, err := downloadDirectory(svc, sourceBucket, sourceKey, destination, conflict, a.opts.transfer, progress)
+			progress.stop()
+			printTransferSummary(progress)
+			return reportBatch(res, err, "Error downloading file", "Folder downloaded successfully (%d files).")
+		default:
+			return usagef("cp -r copies a folder between a local path and an s3:// URI")
+		}
+	}
+
+	switch {
+	case !sourceIsS3 && destinationIsS3:
+		if destinationKey == "" || strings.HasSuffix(destinationKey, "/") {
+			destinationKey += filepath.Base(source)
+		}
+		progress := a.newProgress("Uploaded")
+		err := uploadSingleFile(svc, destinationBucket, source, destinationKey, a.opts.transfer, progress)
+		progress.stop()
+		return report(err, "Error uploading file", "File uploaded successfully.")
+	case sourceIsS3 && !destinationIsS3:
+		if sourceKey == "" {
+			return usagef("%q does not name an object key", source)
+		}
+		if info, err := os.Stat(destination); (err == nil && info.IsDir()) || strings.HasSuffix(destination, string(os.PathSeparator)) {
+			destination = filepath.Join(destination, path.Base(sourceKey))
+		}
+		progress := a.newProgress("Downloaded")
+		err := downloadVersion(svc, sourceBucket, sourceKey, *versionID, destination, a.opts.transfer, progress)
+		progress.stop()
+		return report(err, "Error downloading file", "File downloaded successfully.")
+	case sourceIsS3 && destinationIsS3:
+		if sourceKey == "" {
+			return usagef("%q does not name an object key", source)
+		}
+		if destinationKey == "" || strings.HasSuffix(destinationKey, "/") {
+			destinationKey += path.Base(sourceKey)
+		}
+		err := copyObjectVersion(svc, sourceBucket, sourceKey, *versionID, destinationBucket, destinationKey)
+		return report(err, "Error copying file", "File copied successfully.")
+	default:
+		return usagef("at least one of source and destination must be an s3:// URI")
+	}
+}
+
+func mvCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("mv", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "move a whole folder")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usagef("mv takes a source and a destination")
+	}
+
+	sourceBucket, sourceKey, err := requireS3URI(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	destinationBucket, destinationKey, err := requireS3URI(fs.Arg(1), true)
+	if err != nil {
+		return err
+	}
+	if sourceBucket != destinationBucket {
+		return usagef("mv only works within a single bucket")
+	}
+
+	if *recursive {
+		sourceFolder := strings.TrimSuffix(sourceKey, "/")
+		destinationFolder := strings.TrimSuffix(destinationKey, "/")
+		res, err := moveFolders(svc, sourceBucket, []string{sourceFolder}, []string{destinationFolder}, nil)
+		return reportBatch(res, err, "Error moving object", "Folder moved successfully (%d objects).")
+	}
+	err = renameFile(svc, sourceBucket, sourceKey, destinationKey)
+	return report(err, "Error moving file", "File moved successfully.")
+}
+
+func rmCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "delete whole folders")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usagef("rm needs at least one s3://bucket/key")
+	}
+
+	var bucket string
+	keys := make([]string, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		b, key, err := requireS3URI(arg, true)
+		if err != nil {
+			return err
+		}
+		if bucket != "" && b != bucket {
+			return usagef("rm only works within a single bucket")
+		}
+		bucket = b
+		keys = append(keys, key)
+	}
+
+	if *recursive {
+		var errs []error
+		for _, key := range keys {
+			folder := strings.TrimSuffix(key, "/")
+			if err := a.confirmDeleteFolder(bucket, folder); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			res, err := deleteFolder(svc, bucket, folder)
+			if reportBatch(res, err, "Error deleting folder", "Folder deleted successfully (%d objects).") != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if err := a.confirmDeleteKeys(bucket, keys); err != nil {
+		return err
+	}
+	if len(keys) == 1 {
+		return report(deleteSingleFile(svc, bucket, keys[0]), "Error deleting file", "File deleted successfully.")
+	}
+	res, err := deleteMultipleFiles(svc, bucket, keys)
+	return reportBatch(res, err, "Error deleting file", "%d files deleted successfully.")
+}
+
+func syncCommand(a *app, args []string) error {
+	svc := a.client()
+	var syncOpts syncOptions
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.BoolVar(&syncOpts.delete, "delete", false, "delete files in the destination that are not in the source")
+	fs.BoolVar(&syncOpts.checksum, "checksum", false, "compare files of the same size by checksum instead of modification time")
+	fs.BoolVar(&syncOpts.dryRun, "dry-run", false, "only report what would change")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	syncOpts.dryRun = syncOpts.dryRun || a.opts.dryRun
+	if fs.NArg() != 2 {
+		return usagef("sync takes a source and a destination")
+	}
+	source, target := parseSyncLocation(fs.Arg(0)), parseSyncLocation(fs.Arg(1))
+	if !source.isS3() && !target.isS3() {
+		return usagef("at least one of source and destination must be an s3:// URI")
+	}
+
+	plan, err := planSync(svc, source, target, syncOpts, a.opts.transfer)
+	if err != nil {
+		fmt.Fprintln(errorOutput, "Error syncing:", err)
+		return err
+	}
+	printSyncPlan(plan)
+	if syncOpts.dryRun {
+		return nil
+	}
+	progress := a.newProgress("Synced")
+	res := applySync(svc, plan, a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	return reportBatch(res, res.err(), "Error syncing", "Sync complete (%d changes).")
+}
+
+func presignCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("presign", flag.ContinueOnError)
+	expires := fs.Int64("expires", 60, "URL lifetime in minutes")
+	shorten := fs.Bool("shorten", false, "shorten the URL with tinyurl.com, which then sees the URL")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("presign takes exactly one object")
+	}
+	bucket, key, err := requireS3URI(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	urlStr, err := presignURL(svc, bucket, key, *expires, *shorten)
+	if err != nil {
+		fmt.Fprintln(errorOutput, "Error generating pre-signed URL:", err)
+		return err
+	}
+	fmt.Println(urlStr)
+	return nil
+}
+
+func policyCommand(a *app, args []string) error {
+	svc := a.client()
+	if len(args) == 0 {
+		return usagef("policy needs a subcommand: set or delete")
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 3 {
+			return usagef("policy set takes a bucket and a policy file")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		policy, err := readDocumentFile(args[2])
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error reading policy file:", err)
+			return err
+		}
+		return report(setBucketPolicy(svc, bucket, policy), "Error setting bucket policy", "Bucket policy set successfully.")
+	case "delete":
+		if len(args) != 2 {
+			return usagef("policy delete takes a bucket")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		return report(deleteBucketPolicy(svc, bucket), "Error deleting bucket policy", "Bucket policy deleted successfully.")
+	default:
+		return usagef("unknown policy subcommand %q", args[0])
+	}
+}
+
+func encryptionCommand(a *app, args []string) error {
+	svc := a.client()
+	if len(args) == 0 {
+		return usagef("encryption needs a subcommand: get, set or delete")
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) != 2 {
+			return usagef("encryption get takes a bucket")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		enc, err := getBucketEncryption(svc, bucket)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error getting bucket encryption:", err)
+			return err
+		}
+		printBucketEncryption(enc)
+		return nil
+	case "set":
+		var enc bucketEncryption
+		fs := flag.NewFlagSet("encryption set", flag.ContinueOnError)
+		fs.StringVar(&enc.kmsKeyID, "kms-key-id", "", "KMS key for aws:kms (defaults to the AWS managed key)")
+		fs.BoolVar(&enc.bucketKey, "bucket-key", false, "use an S3 bucket key with aws:kms")
+		if err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return usagef("encryption set takes a bucket and an algorithm: %s", strings.Join(validEncryptions, " or "))
+		}
+		bucket, _, err := requireS3URI(fs.Arg(0), false)
+		if err != nil {
+			return err
+		}
+		enc.algorithm = fs.Arg(1)
+		if !contains(validEncryptions, enc.algorithm) {
+			return usagef("invalid encryption %q; use one of %s", enc.algorithm, strings.Join(validEncryptions, ", "))
+		}
+		return report(setBucketEncryption(svc, bucket, enc), "Error setting bucket encryption", "Bucket encryption set successfully.")
+	case "delete":
+		if len(args) != 2 {
+			return usagef("encryption delete takes a bucket")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		return report(deleteBucketEncryption(svc, bucket), "Error deleting bucket encryption", "Bucket encryption deleted successfully.")
+	default:
+		return usagef("unknown encryption subcommand %q", args[0])
+	}
+}
+
+func lifecycleCommand(a *app, args []string) error {
+	svc := a.client()
+	if len(args) == 0 {
+		return usagef("lifecycle needs a subcommand: get, put or delete")
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) != 2 && len(args) != 3 {
+			return usagef("lifecycle get takes a bucket and optionally a file to save the rules to")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		rules, err := getLifecycle(svc, bucket)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error getting lifecycle rules:", err)
+			return err
+		}
+		if len(args) == 2 {
+			printLifecycle(rules)
+			return nil
+		}
+		if err := saveLifecycle(rules, args[2]); err != nil {
+			fmt.Fprintln(errorOutput, "Error saving lifecycle rules:", err)
+			return err
+		}
+		if args[2] != "-" {
+			fmt.Printf("Lifecycle rules saved to %s.\n", args[2])
+		}
+		return nil
+	case "put":
+		if len(args) != 3 {
+			return usagef("lifecycle put takes a bucket and a rules file")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		rules, err := loadLifecycle(args[2])
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error reading lifecycle rules:", err)
+			return err
+		}
+		return report(putLifecycle(svc, bucket, rules), "Error setting lifecycle rules", "Lifecycle rules set successfully.")
+	case "delete":
+		if len(args) != 2 {
+			return usagef("lifecycle delete takes a bucket")
+		}
+		bucket, _, err := requireS3URI(args[1], false)
+		if err != nil {
+			return err
+		}
+		return report(deleteLifecycle(svc, bucket), "Error deleting lifecycle rules", "Lifecycle rules deleted successfully.")
+	default:
+		return usagef("unknown lifecycle subcommand %q", args[0])
+	}
+}
+
+func versioningCommand(a *app, args []string) error {
+	svc := a.client()
+	if len(args) != 2 {
+		return usagef("versioning takes status, enable or suspend and a bucket")
+	}
+	bucket, _, err := requireS3URI(args[1], false)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := getVersioning(svc, bucket)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error getting bucket versioning:", err)
+			return err
+		}
+		printVersioning(status)
+		return nil
+	case "enable":
+		return report(setVersioning(svc, bucket, true), "Error enabling versioning", "Versioning enabled.")
+	case "suspend":
+		return report(setVersioning(svc, bucket, false), "Error suspending versioning", "Versioning suspended.")
+	default:
+		return usagef("unknown versioning subcommand %q", args[0])
+	}
+}
+
+func versionsCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("versions", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("versions takes exactly one bucket, key or prefix")
+	}
+	bucket, target, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	versions, err := listVersions(svc, bucket, target)
+	if err != nil {
+		fmt.Fprintln(errorOutput, "Error listing versions:", err)
+		return err
+	}
+	printVersions(versions)
+	return nil
+}
+
+func restoreCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	versionID := fs.String("version-id", "", "version to make the latest again")
+	atFlag := fs.String("at", "", "roll the key or prefix back to this time (RFC 3339, or YYYY-MM-DD HH:MM:SS in UTC)")
+	dryRun := fs.Bool("dry-run", false, "only list the changes a restore with -at would make")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*versionID == "") == (*atFlag == "") {
+		return usagef("restore takes either -version-id or -at, and exactly one object or prefix")
+	}
+	if *atFlag != "" {
+		at, err := parseTimestamp(*atFlag)
+		if err != nil {
+			return usagef("%v", err)
+		}
+		bucket, target, err := requireS3URI(fs.Arg(0), false)
+		if err != nil {
+			return err
+		}
+		plan, err := planRestore(svc, bucket, target, at)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error planning restore:", err)
+			return err
+		}
+		printRestorePlan(plan)
+		if *dryRun || a.opts.dryRun || len(plan.actions) == 0 {
+			return nil
+		}
+		if err := a.confirmRestore(plan); err != nil {
+			return err
+		}
+		res := applyRestore(svc, plan)
+		return reportBatch(res, res.err(), "Error restoring", "Restore complete (%d changes).")
+	}
+	bucket, key, err := requireS3URI(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	return report(restoreVersion(svc, bucket, key, *versionID), "Error restoring version", "Version restored successfully.")
+}
+
+func undeleteCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("undelete", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("undelete takes exactly one key or prefix")
+	}
+	bucket, target, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	res, err := undelete(svc, bucket, target)
+	return reportBatch(res, err, "Error undeleting object", "%d objects undeleted.")
+}
+
+// readDocumentFile reads a policy or lifecycle document from a file, or from
+// standard input when the name is "-".
+func readDocumentFile(name string) (string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func aclCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("acl", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usagef("acl takes a bucket and a canned ACL")
+	}
+	bucket, _, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	return report(setBucketACL(svc, bucket, fs.Arg(1)), "Error setting bucket ACL", "Bucket ACL set successfully.")
+}
+
+func infoCommand(a *app, args []string) error {
+	svc := a.client()
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("info takes exactly one bucket or object")
+	}
+	bucket, key, err := requireS3URI(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		info, err := getBucketInfo(svc, bucket)
+		if err != nil {
+			fmt.Fprintln(errorOutput, "Error getting bucket information:", err)
+			return err
+		}
+		printBucketInfo(info)
+		return nil
+	}
+
+	info, err := getObjectInfo(svc, bucket, key)
+	if err != nil {
+		fmt.Fprintln(errorOutput, "Error getting object information:", err)
+		return err
+	}
+	printObjectInfo(info)
+	return nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Client-side encryption wraps every uploaded file in an envelope: the file
+// is encrypted with a random data key of its own, and the data key, encrypted
+// with the master key, is stored in the object's metadata. The master key is
+// read from a key file or derived from a passphrase with PBKDF2, in which
+// case the salt is stored next to the data key.
+//
+// The file is encrypted with AES-256-GCM in chunks of cseChunkSize bytes, so
+// that it can be streamed through multipart uploads and decrypted without
+// holding it in memory. Each chunk's nonce is its index, and its additional
+// data marks the last chunk, so chunks cannot be reordered, dropped or cut
+// off at the end without decryption failing.
+const (
+	cseKeyMetadata  = "cse-key"
+	cseSaltMetadata = "cse-salt"
+
+	cseChunkSize        = 64 * 1024
+	cseSaltSize         = 16
+	csePBKDF2Iterations = 600000
+
+	csePassphraseEnv = "S3INTERACT_CSE_PASSPHRASE"
+)
+
+// cseOptions select client-side encryption. Objects are only decrypted on
+// download if they carry a wrapped data key, so a master key can be given
+// when a bucket mixes encrypted and plain objects.
+type cseOptions struct {
+	keyFile    string
+	passphrase bool
+
+	// master is set by validate when client-side encryption is on.
+	master *masterKey
+}
+
+func (o *cseOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.keyFile, "cse-key", "", "file holding a 256-bit master key, raw or base64, to encrypt uploads client-side and decrypt downloads")
+	fs.BoolVar(&o.passphrase, "cse-passphrase", false, "like -cse-key, with the master key derived from a passphrase read from $"+csePassphraseEnv+" or prompted for")
+}
+
+// validate checks the flags and loads the master key, asking for the
+// passphrase if it is not in the environment.
+func (o *cseOptions) validate() error {
+	switch {
+	case o.keyFile != "" && o.passphrase:
+		return errors.New("-cse-key and -cse-passphrase cannot be combined")
+	case o.keyFile != "":
+		key, err := readKeyFile(o.keyFile)
+		if err != nil {
+			return fmt.Errorf("-cse-key: %w", err)
+		}
+		o.master = &masterKey{key: []byte(key)}
+	case o.passphrase:
+		passphrase := os.Getenv(csePassphraseEnv)
+		if passphrase == "" {
+			fmt.Fprint(os.Stderr, "Enter encryption passphrase: ")
+			passphrase = strings.TrimSpace(readLineUnbuffered(os.Stdin))
+		}
+		if passphrase == "" {
+			return errors.New("-cse-passphrase: the passphrase is empty")
+		}
+		master, err := newPassphraseKey(passphrase)
+		if err != nil {
+			return err
+		}
+		o.master = master
+	}
+	return nil
+}
+
+// readLineUnbuffered reads a line a byte at a time, so that nothing after it
+// is taken from r before the interactive menu starts reading.
+func readLineUnbuffered(r io.Reader) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			break
+		}
+	}
+	return string(line)
+}
+
+// masterKey encrypts the data keys of uploads. A key derived from a
+// passphrase uses a new salt every session; the keys for the salts of
+// objects written in earlier sessions are derived when they are read and
+// kept for the rest of the session.
+type masterKey struct {
+	key        []byte
+	salt       []byte
+	passphrase string
+
+	mu      sync.Mutex
+	derived map[string][]byte
+}
+
+func newPassphraseKey(passphrase string) (*masterKey, error) {
+	salt := make([]byte, cseSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &masterKey{
+		key:        passphraseKey(passphrase, salt),
+		salt:       salt,
+		passphrase: passphrase,
+	}, nil
+}
+
+// passphraseKey derives a 256-bit master key from a passphrase with
+// PBKDF2-HMAC-SHA256. Objects already written depend on these parameters.
+func passphraseKey(passphrase string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, csePBKDF2Iterations, 32, sha256.New)
+}
+
+// keyFor returns the master key for the salt stored with an object.
+func (m *masterKey) keyFor(salt []byte) ([]byte, error) {
+	switch {
+	case m.passphrase == "" && salt != nil:
+		return nil, errors.New("the object was encrypted with a passphrase; use -cse-passphrase")
+	case m.passphrase != "" && salt == nil:
+		return nil, errors.New("the object was encrypted with a key file; use -cse-key")
+	case salt == nil || string(salt) == string(m.salt):
+		return m.key, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key := passphraseKey(m.passphrase, salt)
+	if m.derived == nil {
+		m.derived = make(map[string][]byte)
+	}
+	m.derived[string(salt)] = key
+	return key, nil
+}
+
+// seal returns the metadata for a new object and the data key to encrypt it
+// with.
+func (m *masterKey) seal() (map[string]*string, []byte, error) {
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(m.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped := aead.Seal(nonce, nonce, dataKey, nil)
+	metadata := map[string]*string{cseKeyMetadata: aws.String(base64.StdEncoding.EncodeToString(wrapped))}
+	if m.salt != nil {
+		metadata[cseSaltMetadata] = aws.String(base64.StdEncoding.EncodeToString(m.salt))
+	}
+	return metadata, dataKey, nil
+}
+
+// open returns the data key of an object from its metadata, or nil if the
+// object is not client-side encrypted.
+func (m *masterKey) open(metadata map[string]*string) ([]byte, error) {
+	wrapped, ok := metadataValue(metadata, cseKeyMetadata)
+	if !ok {
+		return nil, nil
+	}
+	if m == nil {
+		return nil, errors.New("the object is client-side encrypted; use -cse-key or -cse-passphrase")
+	}
+
+	var salt []byte
+	if value, ok := metadataValue(metadata, cseSaltMetadata); ok {
+		var err error
+		if salt, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid %s metadata: %w", cseSaltMetadata, err)
+		}
+	}
+	key, err := m.keyFor(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid %s metadata", cseKeyMetadata)
+	}
+	dataKey, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("the client-side encryption key does not match the one the object was encrypted with")
+	}
+	return dataKey, nil
+}
+
+// metadataValue looks up a user metadata entry, whose name S3 may have
+// changed the case of.
+func metadataValue(metadata map[string]*string, name string) (string, bool) {
+	for key, value := range metadata {
+		if strings.EqualFold(key, name) {
+			return aws.StringValue(value), true
+		}
+	}
+	return "", false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce and chunkData bind a chunk to its place in the object.
+func chunkNonce(nonce []byte, index uint64) []byte {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+func chunkData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptedSize returns the size of a file of size bytes once encrypted. An
+// empty file still becomes one, empty, chunk.
+func encryptedSize(size int64) int64 {
+	chunks := (size + cseChunkSize - 1) / cseChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*16
+}
+
+// plaintextSize is the inverse of encryptedSize.
+func plaintextSize(size int64) int64 {
+	chunks := (size + cseChunkSize + 16 - 1) / (cseChunkSize + 16)
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size - chunks*16
+}
+
+// encryptReader encrypts what it reads from src chunk by chunk.
+type encryptReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	plain []byte
+	nonce []byte
+	out   []byte
+	index uint64
+	done  bool
+}
+
+func newEncryptReader(src io.Reader, dataKey []byte) (*encryptReader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		aead:  aead,
+		src:   bufio.NewReaderSize(src, cseChunkSize),
+		plain: make([]byte, cseChunkSize),
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.plain)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			r.done = true
+		case err != nil:
+			return 0, err
+		default:
+			// A full chunk is the last one if nothing follows it.
+			if _, err := r.src.Peek(1); err == io.EOF {
+				r.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		r.out = r.aead.Seal(r.out[:0], chunkNonce(r.nonce, r.index), r.plain[:n], chunkData(r.done))
+		r.index++
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// decryptTo decrypts an object read from src into dst.
+func decryptTo(dst io.Writer, src io.Reader, dataKey []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	in := bufio.NewReaderSize(src, cseChunkSize+aead.Overhead())
+	sealed := make([]byte, cseChunkSize+aead.Overhead())
+	nonce := make([]byte, aead.NonceSize())
+	var plain []byte
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(in, sealed)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if !last {
+			_, err := in.Peek(1)
+			last = err == io.EOF
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(nonce, index), sealed[:n], chunkData(last))
+		if err != nil {
+			return errors.New("the object's contents do not decrypt; it was changed or cut short")
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decryptFile decrypts the file at encryptedPath into a temporary file that
+// then replaces the one at path, which is left alone if decryption fails.
+func decryptFile(encryptedPath, path string, dataKey []byte) error {
+	src, err := os.Open(encryptedPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(path), ".s3decrypt-*")
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	w := bufio.NewWriter(dst)
+	err = decryptTo(w, src, dataKey)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = dst.Chmod(0o644)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), path)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The RFC 6070 vectors, computed with HMAC-SHA256 instead of HMAC-SHA1, and
+// the PBKDF2 vector of RFC 7914.
+func TestPBKDF2(t *testing.T) {
+	for _, tt := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	} {
+		got := pbkdf2.Key([]byte(tt.password), []byte(tt.salt), tt.iterations, len(tt.want)/2, sha256.New)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("PBKDF2(%q, %q, %d) = %x, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+
+	// Changing how keys are derived would lock out every object encrypted
+	// with a passphrase so far.
+	const want = "6c4a646aad10d067add5fb79d9078a16da83d50f81670a8e7593b249e6d94936"
+	if got := passphraseKey("correct horse battery staple", []byte("0123456789abcdef")); hex.EncodeToString(got) != want {
+		t.Errorf("passphraseKey = %x, want %s", got, want)
+	}
+}
+
+func encryptTestData(t *testing.T, data, dataKey []byte) []byte {
+	t.Helper()
+	r, err := newEncryptReader(bytes.NewReader(data), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	dataKey := randomBytes(32)
+	for _, size := range []int{0, 1, cseChunkSize - 1, cseChunkSize, cseChunkSize + 1, 3 * cseChunkSize, 3*cseChunkSize + 100} {
+		data := randomBytes(size)
+		sealed := encryptTestData(t, data, dataKey)
+		if int64(len(sealed)) != encryptedSize(int64(size)) {
+			t.Errorf("%d bytes encrypt to %d, want encryptedSize %d", size, len(sealed), encryptedSize(int64(size)))
+		}
+		if got := plaintextSize(int64(len(sealed))); got != int64(size) {
+			t.Errorf("plaintextSize(%d) = %d, want %d", len(sealed), got, size)
+		}
+
+		var plain bytes.Buffer
+		if err := decryptTo(&plain, bytes.NewReader(sealed), dataKey); err != nil {
+			t.Errorf("decrypting %d bytes: %v", size, err)
+		} else if !bytes.Equal(plain.Bytes(), data) {
+			t.Errorf("%d bytes do not survive the round trip", size)
+		}
+	}
+
+	// Readers may ask for less than a chunk at a time.
+	data := randomBytes(cseChunkSize + 10)
+	r, err := newEncryptReader(bytes.NewReader(data), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed, encryptTestData(t, data, dataKey)) {
+		t.Error("reading a byte at a time gives other ciphertext")
+	}
+}
+
+func TestDecryptRejectsChangedData(t *testing.T) {
+	dataKey := randomBytes(32)
+	data := randomBytes(3*cseChunkSize + 100)
+	sealed := encryptTestData(t, data, dataKey)
+	const chunk = cseChunkSize + 16
+
+	changed := func(change func(b []byte) []byte) []byte {
+		return change(append([]byte(nil), sealed...))
+	}
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		dataKey []byte
+	}{
+		{"empty", nil, dataKey},
+		{"truncated", sealed[:len(sealed)-10], dataKey},
+		{"last chunk dropped", sealed[:3*chunk], dataKey},
+		{"chunk appended", append(append([]byte(nil), sealed...), sealed[:chunk]...), dataKey},
+		{"reordered", changed(func(b []byte) []byte {
+			first := append([]byte(nil), b[:chunk]...)
+			copy(b, b[chunk:2*chunk])
+			copy(b[chunk:], first)
+			return b
+		}), dataKey},
+		{"tampered", changed(func(b []byte) []byte {
+			b[chunk+5] ^= 1
+			return b
+		}), dataKey},
+		{"wrong key", sealed, bytes.Repeat([]byte{7}, 32)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := decryptTo(io.Discard, bytes.NewReader(tt.data), tt.dataKey); err == nil {
+				t.Error("decrypted")
+			}
+		})
+	}
+
+	// A file that fails to decrypt leaves the one it would replace alone.
+	dir := t.TempDir()
+	encrypted, path := filepath.Join(dir, "encrypted"), filepath.Join(dir, "file")
+	writeTestFiles(t, dir, map[string]string{"encrypted": string(sealed[:len(sealed)-1]), "file": "old"})
+	if err := decryptFile(encrypted, path, dataKey); err == nil {
+		t.Error("decrypted a truncated file")
+	}
+	if got := readTestFile(t, path); got != "old" {
+		t.Errorf("file = %q after failing to decrypt", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("temporary file left behind: %d entries", len(entries))
+	}
+}
+
+func TestMasterKey(t *testing.T) {
+	keyFile := &masterKey{key: randomBytes(32)}
+	metadata, dataKey, err := keyFile.seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// S3 returns metadata names capitalised.
+	if got, err := keyFile.open(map[string]*string{"Cse-Key": metadata[cseKeyMetadata]}); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("opening with the same key: %v", err)
+	}
+	if _, err := (&masterKey{key: bytes.Repeat([]byte{7}, 32)}).open(metadata); err == nil {
+		t.Error("opened with another key")
+	}
+	var none *masterKey
+	if _, err := none.open(metadata); err == nil {
+		t.Error("opened an encrypted object without a key")
+	}
+	if got, err := none.open(map[string]*string{"Mtime": aws.String("1")}); got != nil || err != nil {
+		t.Errorf("opening a plain object = %v, %v", got, err)
+	}
+
+	session1, err := newPassphraseKey("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, dataKey, err = session1.seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A later session has a salt of its own and derives the key for the
+	// object's salt.
+	session2, err := newPassphraseKey("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(session1.salt, session2.salt) {
+		t.Fatal("two sessions share a salt")
+	}
+	if got, err := session2.open(metadata); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("opening in a later session: %v", err)
+	}
+	wrong, err := newPassphraseKey("wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.open(metadata); err == nil {
+		t.Error("opened with the wrong passphrase")
+	}
+	if _, err := keyFile.open(metadata); err == nil {
+		t.Error("opened a passphrase object with a key file")
+	}
+	keyFileMetadata, _, err := keyFile.seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session2.open(keyFileMetadata); err == nil {
+		t.Error("opened a key file object with a passphrase")
+	}
+
+	metadata[cseSaltMetadata] = aws.String("not base64!")
+	if _, err := session2.open(metadata); err == nil {
+		t.Error("opened with a corrupt salt")
+	}
+}
+
+func TestCSEOptions(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key")
+	writeTestFiles(t, filepath.Dir(keyPath), map[string]string{"key": base64.StdEncoding.EncodeToString(randomBytes(32))})
+
+	o := cseOptions{keyFile: keyPath, passphrase: true}
+	if err := o.validate(); err == nil {
+		t.Error("accepted both a key file and a passphrase")
+	}
+	o = cseOptions{keyFile: keyPath}
+	if err := o.validate(); err != nil || len(o.master.key) != 32 {
+		t.Errorf("loading the key file: %v", err)
+	}
+	t.Setenv(csePassphraseEnv, "from the environment")
+	o = cseOptions{passphrase: true}
+	if err := o.validate(); err != nil || o.master.passphrase != "from the environment" {
+		t.Errorf("reading the passphrase: %v", err)
+	}
+	if err := (&cseOptions{}).validate(); err != nil {
+		t.Errorf("without encryption: %v", err)
+	}
+}
+
+func TestClientSideEncryptedTransfer(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	t.Setenv(csePassphraseEnv, "secret")
+	encrypted := testTransfer
+	encrypted.cse.passphrase = true
+	if err := encrypted.cse.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	data := randomBytes(testPartSize + cseChunkSize + 1)
+	writeTestFiles(t, dir, map[string]string{"src": string(data), "plain": "visible"})
+	if err := uploadSingleFile(svc, "b", filepath.Join(dir, "src"), "secret", encrypted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadSingleFile(svc, "b", filepath.Join(dir, "plain"), "plain", testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := readTestObject(t, svc, "b", "secret")
+	if int64(len(stored)) != encryptedSize(int64(len(data))) || bytes.Contains([]byte(stored), data[:64]) {
+		t.Fatalf("stored %d bytes, which are not the encrypted file", len(stored))
+	}
+
+	if err := downloadSingleFile(svc, "b", "secret", filepath.Join(dir, "out"), encrypted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(readTestFile(t, filepath.Join(dir, "out"))), data) {
+		t.Error("downloaded file differs from the uploaded one")
+	}
+	// Plain objects download as they are with a key.
+	if err := downloadSingleFile(svc, "b", "plain", filepath.Join(dir, "plain-out"), encrypted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "plain-out")); got != "visible" {
+		t.Errorf("plain object downloaded as %q", got)
+	}
+
+	if err := downloadSingleFile(svc, "b", "secret", filepath.Join(dir, "nokey"), testTransfer, nil); err == nil {
+		t.Error("downloaded an encrypted object without a key")
+	}
+	t.Setenv(csePassphraseEnv, "guess")
+	wrong := testTransfer
+	wrong.cse.passphrase = true
+	if err := wrong.cse.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadSingleFile(svc, "b", "secret", filepath.Join(dir, "out"), wrong, nil); err == nil {
+		t.Error("downloaded with the wrong passphrase")
+	}
+	if !bytes.Equal([]byte(readTestFile(t, filepath.Join(dir, "out"))), data) {
+		t.Error("a failed download replaced the earlier one")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// dryRunClient is an s3iface.S3API that passes reads through to the wrapped
+// client but records every write instead of sending it, so that the usual
+// operations can run unchanged in dry-run mode. Object sizes are taken from
+// the listings the operations make, or read with HeadObject.
+type dryRunClient struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	changes []dryRunChange
+	sizes   map[string]int64
+}
+
+// dryRunChange is a write that was not made.
+type dryRunChange struct {
+	operation string
+	target    string
+	detail    string
+	size      int64
+}
+
+func newDryRunClient(svc s3iface.S3API) *dryRunClient {
+	return &dryRunClient{S3API: svc, sizes: make(map[string]int64)}
+}
+
+func (d *dryRunClient) record(operation, target, detail string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.changes = append(d.changes, dryRunChange{operation: operation, target: target, detail: detail, size: size})
+}
+
+// size returns the size of an object or object version, or 0 if it does not
+// exist.
+func (d *dryRunClient) size(bucket, key, versionID string) int64 {
+	d.mu.Lock()
+	size, ok := d.sizes[sizeKey(bucket, key, versionID)]
+	d.mu.Unlock()
+	if ok {
+		return size
+	}
+
+	input := &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	head, err := d.S3API.HeadObject(input)
+	if err != nil {
+		return 0
+	}
+	return aws.Int64Value(head.ContentLength)
+}
+
+func sizeKey(bucket, key, versionID string) string {
+	return bucket + "/" + key + "?versionId=" + versionID
+}
+
+func objectURI(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+func versionDetail(versionID *string) string {
+	if aws.StringValue(versionID) == "" {
+		return ""
+	}
+	return "version " + aws.StringValue(versionID)
+}
+
+func (d *dryRunClient) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	bucket := aws.StringValue(input.Bucket)
+	return d.S3API.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		d.mu.Lock()
+		for _, item := range page.Contents {
+			d.sizes[sizeKey(bucket, aws.StringValue(item.Key), "")] = aws.Int64Value(item.Size)
+		}
+		d.mu.Unlock()
+		return fn(page, lastPage)
+	})
+}
+
+func (d *dryRunClient) ListObjectVersionsPages(input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	bucket := aws.StringValue(input.Bucket)
+	return d.S3API.ListObjectVersionsPages(input, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		d.mu.Lock()
+		for _, version := range page.Versions {
+			d.sizes[sizeKey(bucket, aws.StringValue(version.Key), aws.StringValue(version.VersionId))] = aws.Int64Value(version.Size)
+		}
+		for _, marker := range page.DeleteMarkers {
+			d.sizes[sizeKey(bucket, aws.StringValue(marker.Key), aws.StringValue(marker.VersionId))] = 0
+		}
+		d.mu.Unlock()
+		return fn(page, lastPage)
+	})
+}
+
+func (d *dryRunClient) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	var detail []string
+	if input.CreateBucketConfiguration != nil {
+		detail = append(detail, "in "+aws.StringValue(input.CreateBucketConfiguration.LocationConstraint))
+	}
+	if aws.BoolValue(input.ObjectLockEnabledForBucket) {
+		detail = append(detail, "object lock")
+	}
+	if input.ObjectOwnership != nil {
+		detail = append(detail, "ownership "+aws.StringValue(input.ObjectOwnership))
+	}
+	if input.ACL != nil {
+		detail = append(detail, "ACL "+aws.StringValue(input.ACL))
+	}
+	d.record("create bucket", "s3://"+aws.StringValue(input.Bucket), strings.Join(detail, ", "), 0)
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (d *dryRunClient) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+	var algorithms []string
+	for _, rule := range input.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault != nil {
+			algorithms = append(algorithms, aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+		}
+	}
+	d.record("set encryption", "s3://"+aws.StringValue(input.Bucket), strings.Join(algorithms, ", "), 0)
+	return &s3.PutBucketEncryptionOutput{}, nil
+}
+
+func (d *dryRunClient) DeleteBucketEncryption(input *s3.DeleteBucketEncryptionInput) (*s3.DeleteBucketEncryptionOutput, error) {
+	d.record("delete encryption", "s3://"+aws.StringValue(input.Bucket), "", 0)
+	return &s3.DeleteBucketEncryptionOutput{}, nil
+}
+
+func (d *dryRunClient) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	d.record("set versioning", "s3://"+aws.StringValue(input.Bucket), aws.StringValue(input.VersioningConfiguration.Status), 0)
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (d *dryRunClient) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	d.record("set lifecycle", "s3://"+aws.StringValue(input.Bucket), fmt.Sprintf("%d rules", len(input.LifecycleConfiguration.Rules)), 0)
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (d *dryRunClient) DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error) {
+	d.record("delete lifecycle", "s3://"+aws.StringValue(input.Bucket), "", 0)
+	return &s3.DeleteBucketLifecycleOutput{}, nil
+}
+
+func (d *dryRunClient) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	d.record("block public access", "s3://"+aws.StringValue(input.Bucket), "", 0)
+	return &s3.PutPublicAccessBlockOutput{}, nil
+}
+
+func (d *dryRunClient) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	d.record("delete bucket", "s3://"+aws.StringValue(input.Bucket), "", 0)
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+func (d *dryRunClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var size int64
+	if input.Body != nil {
+		size, _ = input.Body.Seek(0, io.SeekEnd)
+	}
+	d.record("put", objectURI(aws.StringValue(input.Bucket), aws.StringValue(input.Key)), "", size)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// Upload records an upload made through the transfer engine; newUploader
+// returns the dry-run client itself in place of an uploader.
+func (d *dryRunClient) Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	var size int64
+	if seeker, ok := input.Body.(io.Seeker); ok {
+		size, _ = seeker.Seek(0, io.SeekEnd)
+	}
+	d.record("upload", objectURI(aws.StringValue(input.Bucket), aws.StringValue(input.Key)), "", size)
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (d *dryRunClient) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	sourceBucket, sourceKey, versionID := parseCopySource(aws.StringValue(input.CopySource))
+
+	detail := "from " + objectURI(sourceBucket, sourceKey)
+	if versionID != "" {
+		detail += " " + versionDetail(&versionID)
+	}
+	d.record("copy", objectURI(aws.StringValue(input.Bucket), aws.StringValue(input.Key)), detail, d.size(sourceBucket, sourceKey, versionID))
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{}}, nil
+}
+
+func (d *dryRunClient) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	d.record("delete", objectURI(bucket, key), versionDetail(input.VersionId), d.size(bucket, key, aws.StringValue(input.VersionId)))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (d *dryRunClient) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range input.Delete.Objects {
+		key := aws.StringValue(object.Key)
+		d.record("delete", objectURI(bucket, key), versionDetail(object.VersionId), d.size(bucket, key, aws.StringValue(object.VersionId)))
+		output.Deleted = append(output.Deleted, &s3.DeletedObject{Key: object.Key, VersionId: object.VersionId})
+	}
+	return output, nil
+}
+
+func (d *dryRunClient) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	d.record("set policy", "s3://"+aws.StringValue(input.Bucket), aws.StringValue(input.Policy), 0)
+	return &s3.PutBucketPolicyOutput{}, nil
+}
+
+func (d *dryRunClient) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	d.record("delete policy", "s3://"+aws.StringValue(input.Bucket), "", 0)
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
+
+func (d *dryRunClient) PutBucketAcl(input *s3.PutBucketAclInput) (*s3.PutBucketAclOutput, error) {
+	d.record("set ACL", "s3://"+aws.StringValue(input.Bucket), aws.StringValue(input.ACL), 0)
+	return &s3.PutBucketAclOutput{}, nil
+}
+
+func (d *dryRunClient) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	d.record("abort upload", objectURI(aws.StringValue(input.Bucket), aws.StringValue(input.Key)), "upload "+aws.StringValue(input.UploadId), 0)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
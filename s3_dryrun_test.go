@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// uploaderCalls are the S3API methods s3manager.Uploader calls on the client
+// it is given.
+var uploaderCalls = []string{
+	"AbortMultipartUploadWithContext",
+	"CompleteMultipartUploadWithContext",
+	"CreateMultipartUploadWithContext",
+	"GetObjectRequest",
+	"PutObjectRequest",
+	"UploadPartWithContext",
+}
+
+// s3Source holds the parsed non-test files of the package.
+type s3Source struct {
+	files []*ast.File
+}
+
+func parseS3Source(t *testing.T) s3Source {
+	t.Helper()
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src s3Source
+	for _, file := range pkgs["main"].Files {
+		src.files = append(src.files, file)
+	}
+	return src
+}
+
+// receiverName returns the name of the type a method is declared on, or ""
+// for a function.
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil {
+		return ""
+	}
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// wrappers returns the types that embed an S3 client to change some of its
+// methods.
+func (src s3Source) wrappers() map[string]bool {
+	wrappers := make(map[string]bool)
+	for _, file := range src.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				for _, field := range st.Fields.List {
+					if len(field.Names) > 0 {
+						continue
+					}
+					if name := typeString(field.Type); name == "s3iface.S3API" || name == "*s3.S3" {
+						wrappers[spec.Name.Name] = true
+					}
+				}
+			}
+			return false
+		})
+	}
+	return wrappers
+}
+
+// typeString returns the source form of a selector type such as *s3.S3.
+func typeString(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + typeString(e.X)
+	case *ast.SelectorExpr:
+		return typeString(e.X) + "." + e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// operationCalls returns the S3API methods the operations call, directly or
+// through s3manager.Uploader. Calls that the client wrappers forward to the
+// client they wrap are left out, as they only stand in for calls made
+// elsewhere.
+func (src s3Source) operationCalls() []string {
+	api := reflect.TypeOf((*s3iface.S3API)(nil)).Elem()
+	wrappers := src.wrappers()
+	calls := make(map[string]bool)
+	for _, name := range uploaderCalls {
+		calls[name] = true
+	}
+	for _, file := range src.files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || wrappers[receiverName(fn)] {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+					if _, ok := api.MethodByName(sel.Sel.Name); ok {
+						calls[sel.Sel.Name] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	var names []string
+	for name := range calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// methods returns the methods declared on typeName itself, not promoted from
+// a type it embeds.
+func (src s3Source) methods(typeName string) map[string]bool {
+	methods := make(map[string]bool)
+	for _, file := range src.files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && receiverName(fn) == typeName {
+				methods[fn.Name.Name] = true
+			}
+		}
+	}
+	return methods
+}
+
+func TestDryRunRecordsWritesWithoutMakingThem(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	putTestObject(t, svc, "b", "dir/a", "12345")
+	putTestObject(t, svc, "b", "dir/b", "123")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"new.txt": "1234567"})
+	rec.take()
+
+	dry := newDryRunClient(svc)
+	if _, err := uploadDirectory(dry, "b", dir, "up", directoryUploadOptions{}, testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := moveFolders(dry, "b", []string{"dir"}, []string{"moved"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deleteFolder(dry, "b", "dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range rec.take() {
+		if r.method != "GET" && r.method != "HEAD" {
+			t.Errorf("dry run sent %s %s?%s", r.method, r.path, r.query)
+		}
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"dir/a", "dir/b"}) {
+		t.Errorf("keys = %v", keys)
+	}
+
+	// Moves copy and delete object by object, in parallel, so only the set
+	// of changes is fixed.
+	want := []string{
+		"copy s3://b/moved/a from s3://b/dir/a (5)",
+		"copy s3://b/moved/b from s3://b/dir/b (3)",
+		"delete s3://b/dir/ (0)",
+		"delete s3://b/dir/a (5)",
+		"delete s3://b/dir/a (5)",
+		"delete s3://b/dir/b (3)",
+		"delete s3://b/dir/b (3)",
+		"upload s3://b/up/new.txt (7)",
+	}
+	var got []string
+	for _, c := range dry.changes {
+		got = append(got, strings.TrimSpace(fmt.Sprintf("%s %s %s", c.operation, c.target, c.detail))+fmt.Sprintf(" (%d)", c.size))
+	}
+	sort.Strings(got)
+	if !equalStrings(got, want) {
+		t.Errorf("recorded %q, want %q", got, want)
+	}
+}
+
+// TestDryRunCoversOperations fails when an operation calls a write API that
+// dryRunClient passes through. The uploader's calls are left out: in dry-run
+// mode newUploader returns the dry-run client, which records the upload.
+func TestDryRunCoversOperations(t *testing.T) {
+	src := parseS3Source(t)
+	recorded := src.methods("dryRunClient")
+	uploader := make(map[string]bool)
+	for _, name := range uploaderCalls {
+		uploader[name] = true
+	}
+	for _, name := range src.operationCalls() {
+		read := strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "Head") || strings.HasPrefix(name, "List")
+		if !read && !uploader[name] && !recorded[name] {
+			t.Errorf("dryRunClient does not override %s, so dry runs make that change", name)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// encryptionOptions select the server-side encryption of the objects
+// s3interact writes: SSE-S3 (AES256) or SSE-KMS with an optional key and
+// bucket key, or SSE-C with a key the user keeps. An SSE-C key is also sent
+// when reading objects, since S3 cannot decrypt them without it.
+type encryptionOptions struct {
+	sse                   string
+	kmsKeyID              string
+	bucketKey             bool
+	customerKeyFile       string
+	copySourceKeyFile     string
+	customerKey           string
+	copySourceCustomerKey string
+}
+
+func (o *encryptionOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.sse, "sse", "", "server-side encryption of uploaded and copied objects: "+strings.Join(validEncryptions, ", "))
+	fs.StringVar(&o.kmsKeyID, "sse-kms-key-id", "", "KMS key for -sse aws:kms (defaults to the bucket's or the AWS managed key)")
+	fs.BoolVar(&o.bucketKey, "sse-bucket-key", false, "use an S3 bucket key for -sse aws:kms, which cuts KMS requests")
+	fs.StringVar(&o.customerKeyFile, "sse-c-key", "", "file holding a 256-bit key, raw or base64, to encrypt objects written and read them back (SSE-C)")
+	fs.StringVar(&o.copySourceKeyFile, "sse-c-copy-source-key", "", "file holding the SSE-C key of copied objects, if it differs from -sse-c-key")
+}
+
+// validate checks the flags and reads the SSE-C keys.
+func (o *encryptionOptions) validate() error {
+	if o.sse != "" && !contains(validEncryptions, o.sse) {
+		return fmt.Errorf("invalid -sse %q; use one of %s", o.sse, strings.Join(validEncryptions, ", "))
+	}
+	if (o.kmsKeyID != "" || o.bucketKey) && o.sse != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("-sse-kms-key-id and -sse-bucket-key need -sse %s", s3.ServerSideEncryptionAwsKms)
+	}
+	if o.sse != "" && o.customerKeyFile != "" {
+		return errors.New("-sse and -sse-c-key cannot be combined")
+	}
+
+	var err error
+	if o.customerKeyFile != "" {
+		if o.customerKey, err = readKeyFile(o.customerKeyFile); err != nil {
+			return fmt.Errorf("-sse-c-key: %w", err)
+		}
+	}
+	o.copySourceCustomerKey = o.customerKey
+	if o.copySourceKeyFile != "" {
+		if o.copySourceCustomerKey, err = readKeyFile(o.copySourceKeyFile); err != nil {
+			return fmt.Errorf("-sse-c-copy-source-key: %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *encryptionOptions) enabled() bool {
+	return o.sse != "" || o.customerKey != "" || o.copySourceCustomerKey != ""
+}
+
+// readKeyFile reads a 256-bit key, for SSE-C or client-side encryption,
+// stored either as 32 raw bytes or base64-encoded.
+func readKeyFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 32 {
+		return string(data), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return "", errors.New("the key must be 32 bytes, raw or base64-encoded")
+	}
+	return string(key), nil
+}
+
+// encryptingClient is an s3iface.S3API that adds the configured encryption
+// settings to every write, and the SSE-C key to every read, that the
+// operations make. It sits below the dry-run client, so that dry runs can
+// still read SSE-C objects. Any API that writes or reads object data must be
+// overridden here.
+type encryptingClient struct {
+	s3iface.S3API
+	opts encryptionOptions
+}
+
+// newEncryptingClient wraps svc when object encryption is configured.
+func newEncryptingClient(svc s3iface.S3API, opts encryptionOptions) s3iface.S3API {
+	if !opts.enabled() {
+		return svc
+	}
+	return &encryptingClient{S3API: svc, opts: opts}
+}
+
+// customerKey returns the SSE-C algorithm and key, or nils without a key.
+func customerKey(key string) (algorithm, value *string) {
+	if key == "" {
+		return nil, nil
+	}
+	// The SDK base64-encodes the key and adds its MD5.
+	return aws.String(s3.ServerSideEncryptionAes256), aws.String(key)
+}
+
+// serverSide returns the SSE-S3 or SSE-KMS settings of new objects.
+func (c *encryptingClient) serverSide() (algorithm, kmsKeyID *string, bucketKey *bool) {
+	if c.opts.sse == "" {
+		return nil, nil, nil
+	}
+	algorithm = aws.String(c.opts.sse)
+	if c.opts.kmsKeyID != "" {
+		kmsKeyID = aws.String(c.opts.kmsKeyID)
+	}
+	if c.opts.bucketKey {
+		bucketKey = aws.Bool(true)
+	}
+	return algorithm, kmsKeyID, bucketKey
+}
+
+func (c *encryptingClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return c.S3API.PutObject(c.putObjectInput(input))
+}
+
+// PutObjectRequest is used by the transfer engine for files that fit in one
+// part.
+func (c *encryptingClient) PutObjectRequest(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	return c.S3API.PutObjectRequest(c.putObjectInput(input))
+}
+
+func (c *encryptingClient) putObjectInput(input *s3.PutObjectInput) *s3.PutObjectInput {
+	in := *input
+	in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = c.serverSide()
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return &in
+}
+
+func (c *encryptingClient) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return c.S3API.CreateMultipartUpload(c.createMultipartUploadInput(input))
+}
+
+func (c *encryptingClient) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	return c.S3API.CreateMultipartUploadWithContext(ctx, c.createMultipartUploadInput(input), opts...)
+}
+
+func (c *encryptingClient) createMultipartUploadInput(input *s3.CreateMultipartUploadInput) *s3.CreateMultipartUploadInput {
+	in := *input
+	in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = c.serverSide()
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return &in
+}
+
+func (c *encryptingClient) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	in := *input
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return c.S3API.UploadPart(&in)
+}
+
+func (c *encryptingClient) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	in := *input
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return c.S3API.UploadPartWithContext(ctx, &in, opts...)
+}
+
+// CopyObject encrypts the copy like any other write and decrypts an SSE-C
+// source with its key.
+func (c *encryptingClient) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	in := *input
+	in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = c.serverSide()
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey = customerKey(c.opts.copySourceCustomerKey)
+	return c.S3API.CopyObject(&in)
+}
+
+func (c *encryptingClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	in := *input
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return c.S3API.HeadObject(&in)
+}
+
+func (c *encryptingClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	in := *input
+	in.SSECustomerAlgorithm, in.SSECustomerKey = customerKey(c.opts.customerKey)
+	return c.S3API.GetObject(&in)
+}
+
+// objectEncryption describes how an object is encrypted at rest.
+func objectEncryption(head *s3.HeadObjectOutput) string {
+	if head.SSECustomerAlgorithm != nil {
+		return "SSE-C (" + aws.StringValue(head.SSECustomerAlgorithm) + ")"
+	}
+	description := aws.StringValue(head.ServerSideEncryption)
+	if head.SSEKMSKeyId != nil {
+		description += ", key " + aws.StringValue(head.SSEKMSKeyId)
+	}
+	if aws.BoolValue(head.BucketKeyEnabled) {
+		description += ", bucket key"
+	}
+	if _, ok := metadataValue(head.Metadata, cseKeyMetadata); ok {
+		description += ", client-side encrypted"
+	}
+	return description
+}
+
+// bucketEncryption is the default encryption of a bucket.
+type bucketEncryption struct {
+	algorithm string
+	kmsKeyID  string
+	bucketKey bool
+}
+
+// getBucketEncryption returns the default encryption of bucket, or nil if it
+// has none configured.
+func getBucketEncryption(svc s3iface.S3API, bucket string) (*bucketEncryption, error) {
+	result, err := svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{
+		Bucket: aws.String(bucket),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "ServerSideEncryptionConfigurationNotFoundError" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range result.ServerSideEncryptionConfiguration.Rules {
+		if def := rule.ApplyServerSideEncryptionByDefault; def != nil {
+			return &bucketEncryption{
+				algorithm: aws.StringValue(def.SSEAlgorithm),
+				kmsKeyID:  aws.StringValue(def.KMSMasterKeyID),
+				bucketKey: aws.BoolValue(rule.BucketKeyEnabled),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// setBucketEncryption sets the default encryption of bucket. A KMS key and a
+// bucket key only apply to aws:kms.
+func setBucketEncryption(svc s3iface.S3API, bucket string, enc bucketEncryption) error {
+	if !contains(validEncryptions, enc.algorithm) {
+		return fmt.Errorf("invalid encryption %q; use one of %s", enc.algorithm, strings.Join(validEncryptions, ", "))
+	}
+	if (enc.kmsKeyID != "" || enc.bucketKey) && enc.algorithm != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("a KMS key or bucket key needs %s encryption", s3.ServerSideEncryptionAwsKms)
+	}
+
+	def := &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(enc.algorithm)}
+	if enc.kmsKeyID != "" {
+		def.KMSMasterKeyID = aws.String(enc.kmsKeyID)
+	}
+	rule := &s3.ServerSideEncryptionRule{ApplyServerSideEncryptionByDefault: def}
+	if enc.bucketKey {
+		rule.BucketKeyEnabled = aws.Bool(true)
+	}
+	_, err := svc.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(bucket),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{Rules: []*s3.ServerSideEncryptionRule{rule}},
+	})
+	return err
+}
+
+// deleteBucketEncryption removes the default encryption of bucket, which
+// leaves S3's own AES256 default in place.
+func deleteBucketEncryption(svc s3iface.S3API, bucket string) error {
+	_, err := svc.DeleteBucketEncryption(&s3.DeleteBucketEncryptionInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestBucketEncryption(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if enc, err := getBucketEncryption(svc, "b"); err != nil || enc != nil {
+		t.Fatalf("encryption of a new bucket = %+v, %v", enc, err)
+	}
+	if err := setBucketEncryption(svc, "b", bucketEncryption{algorithm: s3.ServerSideEncryptionAes256, bucketKey: true}); err == nil {
+		t.Error("set a bucket key without aws:kms")
+	}
+	want := bucketEncryption{algorithm: s3.ServerSideEncryptionAwsKms, kmsKeyID: "key", bucketKey: true}
+	if err := setBucketEncryption(svc, "b", want); err != nil {
+		t.Fatal(err)
+	}
+	if enc, err := getBucketEncryption(svc, "b"); err != nil || enc == nil || *enc != want {
+		t.Errorf("encryption = %+v, %v, want %+v", enc, err, want)
+	}
+	if err := deleteBucketEncryption(svc, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if enc, err := getBucketEncryption(svc, "b"); err != nil || enc != nil {
+		t.Errorf("encryption after deleting = %+v, %v", enc, err)
+	}
+}
+
+func TestCustomerKeyEncryption(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	key := strings.Repeat("k", 32)
+	sse := newEncryptingClient(svc, encryptionOptions{customerKey: key, copySourceCustomerKey: key})
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "secret"})
+
+	if err := uploadSingleFile(sse, "b", filepath.Join(dir, "a.txt"), "a", testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readTestObject(t, svc, "b", "a"); ok {
+		t.Error("read an SSE-C object without its key")
+	}
+	if got, _ := readTestObject(t, sse, "b", "a"); got != "secret" {
+		t.Errorf("read %q with the key", got)
+	}
+	head, err := sse.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("b"), Key: aws.String("a")})
+	if err != nil || objectEncryption(head) != "SSE-C (AES256)" {
+		t.Errorf("head = %v, %v", head, err)
+	}
+
+	if err := copyObject(sse, "b", "a", "b", "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadSingleFile(sse, "b", "copy", filepath.Join(dir, "copy"), testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "copy")); got != "secret" {
+		t.Errorf("downloaded copy holds %q", got)
+	}
+}
+
+// TestCustomerKeyWithFakeServer uses SSE-C against the -fake server, which
+// only works because the server speaks HTTPS.
+func TestCustomerKeyWithFakeServer(t *testing.T) {
+	sess, err := newFakeSession(sessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := s3.New(sess)
+	if err := createBucket(svc, "b", bucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	key := strings.Repeat("k", 32)
+	sse := newEncryptingClient(svc, encryptionOptions{customerKey: key, copySourceCustomerKey: key})
+	putTestObject(t, sse, "b", "a", "secret")
+	if got, _ := readTestObject(t, sse, "b", "a"); got != "secret" {
+		t.Errorf("read %q with the key", got)
+	}
+}
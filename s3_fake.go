@@ -0,0 +1,1224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 is an in-memory S3 backend that speaks enough of the REST API for
+// every operation s3interact uses: buckets, objects, prefix listings, copies,
+// multi-object deletes, multipart uploads, policies, ACLs, versions and
+// bucket settings such as default encryption and object lock. It
+// only understands path-style requests and does not check signatures.
+//
+// Use client for an s3iface.S3API that calls the fake in-process, or server
+// for a real HTTP endpoint that other tools can also talk to.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	nextID  int
+}
+
+type fakeBucket struct {
+	name       string
+	region     string
+	created    time.Time
+	versioning string
+	policy     string
+	acl        string
+	ownership  string
+	objectLock bool
+	// encryption, publicAccessBlock and lifecycle hold the configuration
+	// documents as they were put.
+	encryption        []byte
+	publicAccessBlock []byte
+	lifecycle         []byte
+	// objects maps each key to its versions, oldest first.
+	objects map[string][]*fakeVersion
+	// uploads holds the multipart uploads in progress, by upload ID.
+	uploads map[string]*fakeUpload
+}
+
+type fakeVersion struct {
+	id           string
+	deleteMarker bool
+	data         []byte
+	etag         string
+	contentType  string
+	metadata     map[string]string
+	encryption   fakeEncryption
+	lastModified time.Time
+}
+
+// fakeEncryption is the server-side encryption of an object. The fake keeps
+// data in the clear; it only records the settings, and for SSE-C the key's
+// MD5, so that reads must present the same key.
+type fakeEncryption struct {
+	algorithm      string
+	kmsKeyID       string
+	bucketKey      bool
+	customerKeyMD5 string
+}
+
+type fakeUpload struct {
+	id          string
+	key         string
+	initiated   time.Time
+	contentType string
+	metadata    map[string]string
+	encryption  fakeEncryption
+	parts       map[int][]byte
+}
+
+const (
+	// fakeEndpoint uses https, although no connection is made, because the
+	// SDK refuses to send SSE-C keys over plain HTTP.
+	fakeEndpoint = "https://s3.fake.local"
+	fakeOwnerID  = "fakeowner"
+)
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: make(map[string]*fakeBucket)}
+}
+
+// config returns a client configuration that routes every request straight
+// to the fake's handler without opening a network connection.
+func (f *fakeS3) config() *aws.Config {
+	return &aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(fakeEndpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("fake", "fake", ""),
+		HTTPClient:       &http.Client{Transport: fakeTransport{handler: f}},
+	}
+}
+
+// client returns an s3iface.S3API backed by the fake. The session is built
+// without reading the environment, so settings such as AWS_CA_BUNDLE or
+// AWS_PROFILE cannot interfere.
+func (f *fakeS3) client() s3iface.S3API {
+	config := defaults.Config()
+	config.MergeIn(f.config())
+	return s3.New(&session.Session{Config: config, Handlers: defaults.Handlers()})
+}
+
+// server starts an HTTPS server backed by the fake, so that SSE-C works
+// against it too. Clients must use its URL as their endpoint with path-style
+// addressing, and the server's Client to trust its certificate.
+func (f *fakeS3) server() *httptest.Server {
+	return httptest.NewTLSServer(f)
+}
+
+// fakeTransport is an http.RoundTripper that hands requests to a handler.
+type fakeTransport struct {
+	handler http.Handler
+}
+
+func (t fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		// Outgoing requests may have no body, but handlers expect one.
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// fakeError is an S3 error response.
+type fakeError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *fakeError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func errNoSuchBucket(bucket string) *fakeError {
+	return &fakeError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist: " + bucket}
+}
+
+func errNoSuchKey(key string) *fakeError {
+	return &fakeError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist: " + key}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	switch {
+	case bucket == "":
+		err = f.listBuckets(w, r)
+	case key == "":
+		err = f.serveBucket(w, r, bucket, query)
+	default:
+		err = f.serveObject(w, r, bucket, key, query)
+	}
+
+	if err != nil {
+		ferr, ok := err.(*fakeError)
+		if !ok {
+			ferr = &fakeError{http.StatusInternalServerError, "InternalError", err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(ferr.status)
+		if r.Method != http.MethodHead {
+			xml.NewEncoder(w).Encode(struct {
+				XMLName xml.Name `xml:"Error"`
+				Code    string
+				Message string
+			}{Code: ferr.code, Message: ferr.message})
+		}
+	}
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) error {
+	if r.Method == http.MethodPut && len(query) == 0 {
+		return f.createBucket(w, r, bucket)
+	}
+
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("x-amz-bucket-region", b.region)
+		return nil
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			location := b.region
+			if location == "us-east-1" {
+				location = ""
+			}
+			return writeXML(w, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Value   string   `xml:",chardata"`
+			}{Value: location})
+		case query.Has("policy"):
+			if b.policy == "" {
+				return &fakeError{http.StatusNotFound, "NoSuchBucketPolicy", "The bucket policy does not exist"}
+			}
+			_, err := io.WriteString(w, b.policy)
+			return err
+		case query.Has("acl"):
+			return writeXML(w, fakeACL(b.acl))
+		case query.Has("versioning"):
+			return writeXML(w, struct {
+				XMLName xml.Name `xml:"VersioningConfiguration"`
+				Status  string   `xml:",omitempty"`
+			}{Status: b.versioning})
+		case query.Has("versions"):
+			return f.listObjectVersions(w, b, query)
+		case query.Has("encryption"):
+			if b.encryption == nil {
+				return &fakeError{http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found"}
+			}
+			_, err := w.Write(b.encryption)
+			return err
+		case query.Has("publicAccessBlock"):
+			if b.publicAccessBlock == nil {
+				return &fakeError{http.StatusNotFound, "NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found"}
+			}
+			_, err := w.Write(b.publicAccessBlock)
+			return err
+		case query.Has("lifecycle"):
+			if b.lifecycle == nil {
+				return &fakeError{http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist"}
+			}
+			_, err := w.Write(b.lifecycle)
+			return err
+		case query.Has("object-lock"):
+			if !b.objectLock {
+				return &fakeError{http.StatusNotFound, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket"}
+			}
+			return writeXML(w, struct {
+				XMLName           xml.Name `xml:"ObjectLockConfiguration"`
+				ObjectLockEnabled string
+			}{ObjectLockEnabled: "Enabled"})
+		case query.Has("ownershipControls"):
+			if b.ownership == "" {
+				return &fakeError{http.StatusNotFound, "OwnershipControlsNotFoundError", "The bucket ownership controls were not found"}
+			}
+			return writeXML(w, struct {
+				XMLName         xml.Name `xml:"OwnershipControls"`
+				ObjectOwnership string   `xml:"Rule>ObjectOwnership"`
+			}{ObjectOwnership: b.ownership})
+		case query.Has("uploads"):
+			return f.listMultipartUploads(w, b, query)
+		default:
+			return f.listObjectsV2(w, b, query)
+		}
+	case http.MethodPut:
+		switch {
+		case query.Has("policy"):
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			b.policy = string(body)
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		case query.Has("acl"):
+			b.acl = r.Header.Get("x-amz-acl")
+			return nil
+		case query.Has("versioning"):
+			var config struct {
+				Status string
+			}
+			if err := readXML(r, &config); err != nil {
+				return err
+			}
+			if b.objectLock && config.Status != "Enabled" {
+				return &fakeError{http.StatusConflict, "InvalidBucketState", "Versioning cannot be suspended on a bucket with Object Lock enabled"}
+			}
+			b.versioning = config.Status
+			return nil
+		case query.Has("encryption"):
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			b.encryption = body
+			return nil
+		case query.Has("publicAccessBlock"):
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			b.publicAccessBlock = body
+			return nil
+		case query.Has("lifecycle"):
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			b.lifecycle = body
+			return nil
+		}
+	case http.MethodDelete:
+		switch {
+		case query.Has("policy"):
+			b.policy = ""
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		case query.Has("encryption"):
+			b.encryption = nil
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		case query.Has("publicAccessBlock"):
+			b.publicAccessBlock = nil
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		case query.Has("lifecycle"):
+			b.lifecycle = nil
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		if len(b.objects) > 0 || len(b.uploads) > 0 {
+			return &fakeError{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+		}
+		delete(f.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodPost:
+		if query.Has("delete") {
+			return f.deleteObjects(w, r, b)
+		}
+	}
+	return &fakeError{http.StatusNotImplemented, "NotImplemented", "The fake does not implement this bucket operation"}
+}
+
+func (f *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string, query url.Values) error {
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+
+	if query.Has("uploads") || query.Has("uploadId") {
+		return f.serveMultipart(w, r, b, key, query)
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return f.getObject(w, r, b, key, query.Get("versionId"))
+	case http.MethodPut:
+		if source := r.Header.Get("x-amz-copy-source"); source != "" {
+			return f.copyObject(w, r, b, key, source)
+		}
+		return f.putObject(w, r, b, key)
+	case http.MethodDelete:
+		return f.deleteObject(w, b, key, query.Get("versionId"))
+	}
+	return &fakeError{http.StatusNotImplemented, "NotImplemented", "The fake does not implement this object operation"}
+}
+
+func (f *fakeS3) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	names := make([]string, 0, len(f.buckets))
+	for name := range f.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type bucketEntry struct {
+		Name         string
+		CreationDate string
+	}
+	var result struct {
+		XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+		OwnerID string        `xml:"Owner>ID"`
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}
+	result.OwnerID = fakeOwnerID
+	for _, name := range names {
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: formatTime(f.buckets[name].created)})
+	}
+	return writeXML(w, result)
+}
+
+func (f *fakeS3) createBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if _, exists := f.buckets[bucket]; exists {
+		return &fakeError{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it"}
+	}
+
+	var config struct {
+		LocationConstraint string
+	}
+	if r.ContentLength != 0 {
+		if err := readXML(r, &config); err != nil {
+			return err
+		}
+	}
+	region := config.LocationConstraint
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	acl := r.Header.Get("x-amz-acl")
+	if acl == "" {
+		acl = "private"
+	}
+	b := &fakeBucket{
+		name:      bucket,
+		region:    region,
+		created:   time.Now().UTC(),
+		acl:       acl,
+		ownership: r.Header.Get("x-amz-object-ownership"),
+		objects:   make(map[string][]*fakeVersion),
+		uploads:   make(map[string]*fakeUpload),
+	}
+	if r.Header.Get("x-amz-bucket-object-lock-enabled") == "true" {
+		// Object lock needs versioning, which S3 turns on with it.
+		b.objectLock = true
+		b.versioning = "Enabled"
+	}
+	f.buckets[bucket] = b
+	w.Header().Set("Location", "/"+bucket)
+	return nil
+}
+
+func (f *fakeS3) listObjectsV2(w http.ResponseWriter, b *fakeBucket, query url.Values) error {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &fakeError{http.StatusBadRequest, "InvalidArgument", "Invalid max-keys"}
+		}
+		maxKeys = n
+	}
+
+	start := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return &fakeError{http.StatusBadRequest, "InvalidArgument", "Invalid continuation token"}
+		}
+		start = string(decoded)
+	}
+
+	type objectEntry struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type prefixEntry struct {
+		Prefix string
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []objectEntry
+		CommonPrefixes        []prefixEntry
+	}
+	result.Name = b.name
+	result.Prefix = prefix
+	result.Delimiter = delimiter
+	result.StartAfter = query.Get("start-after")
+	result.ContinuationToken = query.Get("continuation-token")
+	result.MaxKeys = maxKeys
+
+	last := ""
+	for _, key := range b.sortedKeys() {
+		if !strings.HasPrefix(key, prefix) || key <= start {
+			continue
+		}
+		current := b.current(key)
+		if current == nil {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if n := len(result.CommonPrefixes); n > 0 && result.CommonPrefixes[n-1].Prefix == common {
+					last = key
+					continue
+				}
+				if result.KeyCount == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, prefixEntry{Prefix: common})
+				result.KeyCount++
+				last = key
+				continue
+			}
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          key,
+			LastModified: formatTime(current.lastModified),
+			ETag:         current.etag,
+			Size:         int64(len(current.data)),
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		last = key
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+	}
+	return writeXML(w, result)
+}
+
+func (f *fakeS3) listObjectVersions(w http.ResponseWriter, b *fakeBucket, query url.Values) error {
+	prefix := query.Get("prefix")
+	keyMarker := query.Get("key-marker")
+	versionMarker := query.Get("version-id-marker")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &fakeError{http.StatusBadRequest, "InvalidArgument", "Invalid max-keys"}
+		}
+		maxKeys = n
+	}
+
+	type versionEntry struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type markerEntry struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+	}
+	var result struct {
+		XMLName             xml.Name `xml:"ListVersionsResult"`
+		Name                string
+		Prefix              string
+		KeyMarker           string
+		VersionIdMarker     string
+		NextKeyMarker       string `xml:",omitempty"`
+		NextVersionIdMarker string `xml:",omitempty"`
+		MaxKeys             int
+		IsTruncated         bool
+		Versions            []versionEntry `xml:"Version"`
+		DeleteMarkers       []markerEntry  `xml:"DeleteMarker"`
+	}
+	result.Name = b.name
+	result.Prefix = prefix
+	result.KeyMarker = keyMarker
+	result.VersionIdMarker = versionMarker
+	result.MaxKeys = maxKeys
+
+	count := 0
+	lastKey, lastVersion := "", ""
+keys:
+	for _, key := range b.sortedKeys() {
+		if !strings.HasPrefix(key, prefix) || key < keyMarker {
+			continue
+		}
+		if key == keyMarker && versionMarker == "" {
+			continue
+		}
+
+		versions := b.objects[key]
+		skipping := key == keyMarker
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			if skipping {
+				if v.id == versionMarker {
+					skipping = false
+				}
+				continue
+			}
+			if count == maxKeys {
+				result.IsTruncated = true
+				result.NextKeyMarker = lastKey
+				result.NextVersionIdMarker = lastVersion
+				break keys
+			}
+
+			latest := i == len(versions)-1
+			if v.deleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, markerEntry{key, v.id, latest, formatTime(v.lastModified)})
+			} else {
+				result.Versions = append(result.Versions, versionEntry{key, v.id, latest, formatTime(v.lastModified), v.etag, int64(len(v.data)), "STANDARD"})
+			}
+			count++
+			lastKey, lastVersion = key, v.id
+		}
+	}
+	return writeXML(w, result)
+}
+
+func (f *fakeS3) getObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key, versionID string) error {
+	var v *fakeVersion
+	if versionID != "" {
+		v = b.version(key, versionID)
+		if v == nil {
+			return &fakeError{http.StatusNotFound, "NoSuchVersion", "The specified version does not exist"}
+		}
+		if v.deleteMarker {
+			w.Header().Set("x-amz-delete-marker", "true")
+			return &fakeError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against a delete marker"}
+		}
+	} else {
+		v = b.current(key)
+		if v == nil {
+			if versions := b.objects[key]; len(versions) > 0 {
+				w.Header().Set("x-amz-delete-marker", "true")
+			}
+			return errNoSuchKey(key)
+		}
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != v.etag {
+		return &fakeError{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	}
+	if err := v.encryption.checkKey(r.Header, "x-amz-server-side-encryption-customer-key-MD5"); err != nil {
+		return err
+	}
+
+	h := w.Header()
+	v.encryption.setHeaders(h)
+	h.Set("ETag", v.etag)
+	h.Set("Last-Modified", v.lastModified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if v.contentType != "" {
+		h.Set("Content-Type", v.contentType)
+	}
+	if b.versioning != "" {
+		h.Set("x-amz-version-id", v.id)
+	}
+	for name, value := range v.metadata {
+		h.Set("x-amz-meta-"+name, value)
+	}
+
+	data := v.data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, int64(len(data)))
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			return &fakeError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (f *fakeS3) putObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	v := &fakeVersion{
+		data:        data,
+		etag:        md5ETag(data),
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    requestMetadata(r.Header),
+		encryption:  b.requestEncryption(r.Header),
+	}
+	f.addVersion(b, key, v)
+
+	w.Header().Set("ETag", v.etag)
+	v.encryption.setHeaders(w.Header())
+	if b.versioning != "" {
+		w.Header().Set("x-amz-version-id", v.id)
+	}
+	return nil
+}
+
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key, source string) error {
+	sourceBucket, sourceKey, versionID := parseCopySource(source)
+
+	sb, ok := f.buckets[sourceBucket]
+	if !ok {
+		return errNoSuchBucket(sourceBucket)
+	}
+	var sv *fakeVersion
+	if versionID != "" {
+		sv = sb.version(sourceKey, versionID)
+		if sv == nil || sv.deleteMarker {
+			return &fakeError{http.StatusNotFound, "NoSuchVersion", "The specified version does not exist"}
+		}
+	} else if sv = sb.current(sourceKey); sv == nil {
+		return errNoSuchKey(sourceKey)
+	}
+	if err := sv.encryption.checkKey(r.Header, "x-amz-copy-source-server-side-encryption-customer-key-MD5"); err != nil {
+		return err
+	}
+
+	v := &fakeVersion{
+		data:        sv.data,
+		etag:        sv.etag,
+		contentType: sv.contentType,
+		metadata:    sv.metadata,
+		encryption:  b.requestEncryption(r.Header),
+	}
+	if r.Header.Get("x-amz-metadata-directive") == "REPLACE" {
+		v.contentType = r.Header.Get("Content-Type")
+		v.metadata = requestMetadata(r.Header)
+	}
+	f.addVersion(b, key, v)
+
+	if sb.versioning != "" {
+		w.Header().Set("x-amz-copy-source-version-id", sv.id)
+	}
+	v.encryption.setHeaders(w.Header())
+	if b.versioning != "" {
+		w.Header().Set("x-amz-version-id", v.id)
+	}
+	return writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: v.etag, LastModified: formatTime(v.lastModified)})
+}
+
+func (f *fakeS3) deleteObject(w http.ResponseWriter, b *fakeBucket, key, versionID string) error {
+	removed, marker := f.removeVersion(b, key, versionID)
+	if marker != nil {
+		w.Header().Set("x-amz-delete-marker", "true")
+		if b.versioning != "" {
+			w.Header().Set("x-amz-version-id", marker.id)
+		}
+	} else if removed != nil && versionID != "" {
+		w.Header().Set("x-amz-version-id", removed.id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, b *fakeBucket) error {
+	var request struct {
+		Quiet   bool
+		Objects []struct {
+			Key       string
+			VersionId string
+		} `xml:"Object"`
+	}
+	if err := readXML(r, &request); err != nil {
+		return err
+	}
+	if len(request.Objects) > 1000 {
+		return &fakeError{http.StatusBadRequest, "MalformedXML", "A delete request can contain at most 1000 keys"}
+	}
+
+	type deletedEntry struct {
+		Key                   string
+		VersionId             string `xml:",omitempty"`
+		DeleteMarker          bool   `xml:",omitempty"`
+		DeleteMarkerVersionId string `xml:",omitempty"`
+	}
+	var result struct {
+		XMLName xml.Name       `xml:"DeleteResult"`
+		Deleted []deletedEntry `xml:"Deleted"`
+	}
+	for _, object := range request.Objects {
+		_, marker := f.removeVersion(b, object.Key, object.VersionId)
+		if request.Quiet {
+			continue
+		}
+		entry := deletedEntry{Key: object.Key, VersionId: object.VersionId}
+		if marker != nil {
+			entry.DeleteMarker = true
+			if b.versioning != "" {
+				entry.DeleteMarkerVersionId = marker.id
+			}
+		}
+		result.Deleted = append(result.Deleted, entry)
+	}
+	return writeXML(w, result)
+}
+
+func (f *fakeS3) serveMultipart(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string, query url.Values) error {
+	if r.Method == http.MethodPost && query.Has("uploads") {
+		f.nextID++
+		u := &fakeUpload{
+			id:          fmt.Sprintf("upload-%d", f.nextID),
+			key:         key,
+			initiated:   time.Now().UTC(),
+			contentType: r.Header.Get("Content-Type"),
+			metadata:    requestMetadata(r.Header),
+			encryption:  b.requestEncryption(r.Header),
+			parts:       make(map[int][]byte),
+		}
+		b.uploads[u.id] = u
+		return writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: b.name, Key: key, UploadId: u.id})
+	}
+
+	u, ok := b.uploads[query.Get("uploadId")]
+	if !ok || u.key != key {
+		return &fakeError{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || number < 1 || number > 10000 {
+			return &fakeError{http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000"}
+		}
+		if err := u.encryption.checkKey(r.Header, "x-amz-server-side-encryption-customer-key-MD5"); err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		u.parts[number] = data
+		w.Header().Set("ETag", md5ETag(data))
+		return nil
+	case http.MethodPost:
+		return f.completeMultipartUpload(w, r, b, u)
+	case http.MethodDelete:
+		delete(b.uploads, u.id)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return &fakeError{http.StatusNotImplemented, "NotImplemented", "The fake does not implement this multipart operation"}
+}
+
+// completeMultipartUpload joins the listed parts into a new object version.
+// Like S3, every part but the last must be at least 5 MiB and the ETag is
+// the MD5 of the part MD5s followed by the number of parts.
+func (f *fakeS3) completeMultipartUpload(w http.ResponseWriter, r *http.Request, b *fakeBucket, u *fakeUpload) error {
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := readXML(r, &request); err != nil {
+		return err
+	}
+	if len(request.Parts) == 0 {
+		return &fakeError{http.StatusBadRequest, "MalformedXML", "The request must list at least one part"}
+	}
+
+	var data, sums []byte
+	for i, part := range request.Parts {
+		partData, ok := u.parts[part.PartNumber]
+		if !ok || md5ETag(partData) != part.ETag {
+			return &fakeError{http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d was not uploaded or its ETag does not match", part.PartNumber)}
+		}
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			return &fakeError{http.StatusBadRequest, "InvalidPartOrder", "The parts must be listed in ascending order"}
+		}
+		if i < len(request.Parts)-1 && len(partData) < 5*1024*1024 {
+			return &fakeError{http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("Part %d is smaller than the minimum part size", part.PartNumber)}
+		}
+		sum := md5.Sum(partData)
+		sums = append(sums, sum[:]...)
+		data = append(data, partData...)
+	}
+	total := md5.Sum(sums)
+
+	v := &fakeVersion{
+		data:        data,
+		etag:        fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(total[:]), len(request.Parts)),
+		contentType: u.contentType,
+		metadata:    u.metadata,
+		encryption:  u.encryption,
+	}
+	f.addVersion(b, u.key, v)
+	delete(b.uploads, u.id)
+
+	if b.versioning != "" {
+		w.Header().Set("x-amz-version-id", v.id)
+	}
+	return writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: b.name, Key: u.key, ETag: v.etag})
+}
+
+func (f *fakeS3) listMultipartUploads(w http.ResponseWriter, b *fakeBucket, query url.Values) error {
+	prefix := query.Get("prefix")
+
+	uploads := make([]*fakeUpload, 0, len(b.uploads))
+	for _, u := range b.uploads {
+		if strings.HasPrefix(u.key, prefix) {
+			uploads = append(uploads, u)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].key != uploads[j].key {
+			return uploads[i].key < uploads[j].key
+		}
+		return uploads[i].initiated.Before(uploads[j].initiated)
+	})
+
+	type uploadEntry struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	var result struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket      string
+		Prefix      string
+		IsTruncated bool
+		Uploads     []uploadEntry `xml:"Upload"`
+	}
+	result.Bucket = b.name
+	result.Prefix = prefix
+	for _, u := range uploads {
+		result.Uploads = append(result.Uploads, uploadEntry{u.key, u.id, formatTime(u.initiated)})
+	}
+	return writeXML(w, result)
+}
+
+// addVersion stores a new version of key, following the bucket's versioning
+// state: enabled buckets keep every version, while unversioned and suspended
+// buckets replace the "null" version.
+func (f *fakeS3) addVersion(b *fakeBucket, key string, v *fakeVersion) {
+	v.lastModified = time.Now().UTC()
+	if b.versioning == "Enabled" {
+		f.nextID++
+		v.id = fmt.Sprintf("v%015d", f.nextID)
+	} else {
+		v.id = "null"
+		b.dropVersion(key, "null")
+	}
+	b.objects[key] = append(b.objects[key], v)
+}
+
+// removeVersion deletes a specific version of key or, when versionID is
+// empty, deletes the key the way S3 does for the bucket's versioning state.
+// It returns the removed version, if any, and the delete marker that was
+// removed or created, if any.
+func (f *fakeS3) removeVersion(b *fakeBucket, key, versionID string) (removed, marker *fakeVersion) {
+	if versionID != "" {
+		removed = b.dropVersion(key, versionID)
+		if removed != nil && removed.deleteMarker {
+			marker = removed
+		}
+		return removed, marker
+	}
+
+	if b.versioning == "" {
+		return b.dropVersion(key, "null"), nil
+	}
+	if len(b.objects[key]) == 0 {
+		return nil, nil
+	}
+	marker = &fakeVersion{deleteMarker: true}
+	f.addVersion(b, key, marker)
+	return nil, marker
+}
+
+func (b *fakeBucket) sortedKeys() []string {
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// current returns the latest version of key, or nil if the key does not
+// exist or its latest version is a delete marker.
+func (b *fakeBucket) current(key string) *fakeVersion {
+	versions := b.objects[key]
+	if len(versions) == 0 || versions[len(versions)-1].deleteMarker {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func (b *fakeBucket) version(key, versionID string) *fakeVersion {
+	for _, v := range b.objects[key] {
+		if v.id == versionID {
+			return v
+		}
+	}
+	return nil
+}
+
+func (b *fakeBucket) dropVersion(key, versionID string) *fakeVersion {
+	versions := b.objects[key]
+	for i, v := range versions {
+		if v.id == versionID {
+			versions = append(versions[:i:i], versions[i+1:]...)
+			if len(versions) == 0 {
+				delete(b.objects, key)
+			} else {
+				b.objects[key] = versions
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+func fakeACL(canned string) interface{} {
+	type grantee struct {
+		XMLNSXSI string `xml:"xmlns:xsi,attr"`
+		Type     string `xml:"xsi:type,attr"`
+		ID       string `xml:",omitempty"`
+		URI      string `xml:",omitempty"`
+	}
+	type grant struct {
+		Grantee    grantee
+		Permission string
+	}
+	const xsi = "http://www.w3.org/2001/XMLSchema-instance"
+
+	grants := []grant{{grantee{xsi, "CanonicalUser", fakeOwnerID, ""}, "FULL_CONTROL"}}
+	switch canned {
+	case "public-read":
+		grants = append(grants, grant{grantee{xsi, "Group", "", "http://acs.amazonaws.com/groups/global/AllUsers"}, "READ"})
+	case "public-read-write":
+		grants = append(grants,
+			grant{grantee{xsi, "Group", "", "http://acs.amazonaws.com/groups/global/AllUsers"}, "READ"},
+			grant{grantee{xsi, "Group", "", "http://acs.amazonaws.com/groups/global/AllUsers"}, "WRITE"})
+	case "authenticated-read":
+		grants = append(grants, grant{grantee{xsi, "Group", "", "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"}, "READ"})
+	}
+
+	return struct {
+		XMLName xml.Name `xml:"AccessControlPolicy"`
+		OwnerID string   `xml:"Owner>ID"`
+		Grants  []grant  `xml:"AccessControlList>Grant"`
+	}{OwnerID: fakeOwnerID, Grants: grants}
+}
+
+// parseRange parses a single "bytes=" range against an object of the given
+// size and returns the inclusive start and end offsets.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	var err error
+	switch {
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, size > 0
+	case last == "":
+		end = size - 1
+	default:
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end, start <= end && start < size
+}
+
+func requestMetadata(h http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range h {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
+			metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = values[0]
+		}
+	}
+	return metadata
+}
+
+// requestEncryption returns the encryption requested by the headers of a
+// write, or else the bucket's default encryption. Like S3, objects are
+// encrypted with AES256 when neither is set.
+func (b *fakeBucket) requestEncryption(h http.Header) fakeEncryption {
+	if md5 := h.Get("x-amz-server-side-encryption-customer-key-MD5"); md5 != "" {
+		return fakeEncryption{algorithm: h.Get("x-amz-server-side-encryption-customer-algorithm"), customerKeyMD5: md5}
+	}
+	if algorithm := h.Get("x-amz-server-side-encryption"); algorithm != "" {
+		return fakeEncryption{
+			algorithm: algorithm,
+			kmsKeyID:  h.Get("x-amz-server-side-encryption-aws-kms-key-id"),
+			bucketKey: h.Get("x-amz-server-side-encryption-bucket-key-enabled") == "true",
+		}
+	}
+
+	var config struct {
+		Rule struct {
+			SSEAlgorithm     string `xml:"ApplyServerSideEncryptionByDefault>SSEAlgorithm"`
+			KMSMasterKeyID   string `xml:"ApplyServerSideEncryptionByDefault>KMSMasterKeyID"`
+			BucketKeyEnabled bool
+		}
+	}
+	if b.encryption == nil || xml.Unmarshal(b.encryption, &config) != nil || config.Rule.SSEAlgorithm == "" {
+		return fakeEncryption{algorithm: "AES256"}
+	}
+	return fakeEncryption{algorithm: config.Rule.SSEAlgorithm, kmsKeyID: config.Rule.KMSMasterKeyID, bucketKey: config.Rule.BucketKeyEnabled}
+}
+
+// checkKey returns an error unless the SSE-C key MD5 in the named header
+// matches the key the object was written with, or both are absent.
+func (e fakeEncryption) checkKey(h http.Header, header string) error {
+	switch md5 := h.Get(header); {
+	case e.customerKeyMD5 == "" && md5 != "":
+		return &fakeError{http.StatusBadRequest, "InvalidArgument", "The encryption parameters are not applicable to this object"}
+	case e.customerKeyMD5 != md5:
+		return &fakeError{http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object"}
+	}
+	return nil
+}
+
+func (e fakeEncryption) setHeaders(h http.Header) {
+	if e.customerKeyMD5 != "" {
+		h.Set("x-amz-server-side-encryption-customer-algorithm", e.algorithm)
+		h.Set("x-amz-server-side-encryption-customer-key-MD5", e.customerKeyMD5)
+		return
+	}
+	if e.algorithm != "" {
+		h.Set("x-amz-server-side-encryption", e.algorithm)
+	}
+	if e.kmsKeyID != "" {
+		h.Set("x-amz-server-side-encryption-aws-kms-key-id", e.kmsKeyID)
+	}
+	if e.bucketKey {
+		h.Set("x-amz-server-side-encryption-bucket-key-enabled", "true")
+	}
+}
+
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func readXML(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return &fakeError{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
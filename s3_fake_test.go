@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// testRequest is a request that reached the fake.
+type testRequest struct {
+	method string
+	host   string
+	path   string
+	query  string
+}
+
+// recordingHandler passes requests to the fake and remembers them.
+type recordingHandler struct {
+	handler http.Handler
+
+	mu       sync.Mutex
+	requests []testRequest
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.requests = append(h.requests, testRequest{method: r.Method, host: r.URL.Host, path: r.URL.Path, query: r.URL.RawQuery})
+	h.mu.Unlock()
+	h.handler.ServeHTTP(w, r)
+}
+
+// take returns the requests recorded so far and starts over.
+func (h *recordingHandler) take() []testRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	requests := h.requests
+	h.requests = nil
+	return requests
+}
+
+// newTestFake returns a fake with one bucket, a client for it and a record
+// of the requests the client makes.
+func newTestFake(t *testing.T, bucket string) (*fakeS3, s3iface.S3API, *recordingHandler) {
+	t.Helper()
+	f := newFakeS3()
+	rec := &recordingHandler{handler: f}
+	svc := f.client()
+	svc.(*s3.S3).Config.HTTPClient = &http.Client{Transport: fakeTransport{handler: rec}}
+	if bucket != "" {
+		if err := createBucket(svc, bucket, bucketOptions{}); err != nil {
+			t.Fatalf("creating bucket: %v", err)
+		}
+	}
+	rec.take()
+	return f, svc, rec
+}
+
+func putTestObject(t *testing.T, svc s3iface.S3API, bucket, key, body string) *s3.PutObjectOutput {
+	t.Helper()
+	out, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte(body)),
+	})
+	if err != nil {
+		t.Fatalf("putting %s: %v", key, err)
+	}
+	return out
+}
+
+// readTestObject returns the contents of key, or false if it does not
+// exist.
+func readTestObject(t *testing.T, svc s3iface.S3API, bucket, key string) (string, bool) {
+	t.Helper()
+	out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", false
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return string(data), true
+}
+
+func testKeys(t *testing.T, svc s3iface.S3API, bucket, prefix string) []string {
+	t.Helper()
+	objects, err := listObjects(svc, bucket, prefix)
+	if err != nil {
+		t.Fatalf("listing %s: %v", prefix, err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, aws.StringValue(object.Key))
+	}
+	return keys
+}
+
+// writeTestFiles creates files, by slash-separated path relative to dir,
+// with the given contents.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFakeObjects(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha"})
+
+	if err := createFolder(svc, "b", "docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadSingleFile(svc, "b", filepath.Join(dir, "a.txt"), "docs/a.txt", testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rec.take() {
+		if r.host != "s3.fake.local" || r.path != "/b/docs/" && r.path != "/b/docs/a.txt" {
+			t.Errorf("request to %s%s is not path-style", r.host, r.path)
+		}
+	}
+
+	if err := copyObject(svc, "b", "docs/a.txt", "b", "docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := testKeys(t, svc, "b", "docs/"); !equalStrings(keys, []string{"docs/", "docs/a.txt", "docs/b.txt"}) {
+		t.Errorf("keys = %v", keys)
+	}
+	if err := downloadSingleFile(svc, "b", "docs/b.txt", filepath.Join(dir, "b.txt"), testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "b.txt")); got != "alpha" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	listings, err := listBucketsAndObjects(svc)
+	if err != nil || len(listings) != 1 || listings[0].name != "b" || len(listings[0].objects) != 3 {
+		t.Errorf("listing = %+v, %v", listings, err)
+	}
+
+	if err := deleteSingleFile(svc, "b", "docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readTestObject(t, svc, "b", "docs/b.txt"); ok {
+		t.Error("docs/b.txt still exists")
+	}
+	if _, err := deleteFolder(svc, "b", "docs"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := testKeys(t, svc, "b", ""); len(keys) != 0 {
+		t.Errorf("keys left = %v", keys)
+	}
+	if err := downloadSingleFile(svc, "b", "missing", filepath.Join(dir, "missing"), testTransfer, nil); err == nil {
+		t.Error("downloaded a missing key")
+	}
+}
+
+func TestCopyKeysThatNeedEscaping(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	for _, key := range []string{"a?versionId=x", "100% sure", "c++/x y#z", "ünï/çødé"} {
+		putTestObject(t, svc, "b", key, "data")
+
+		if err := copyObject(svc, "b", key, "b", "copy/"+key); err != nil {
+			t.Fatalf("copying %q: %v", key, err)
+		}
+		if got, _ := readTestObject(t, svc, "b", "copy/"+key); got != "data" {
+			t.Errorf("copy of %q holds %q", key, got)
+		}
+		if err := renameFile(svc, "b", "copy/"+key, "moved/"+key); err != nil {
+			t.Fatalf("renaming %q: %v", key, err)
+		}
+		if got, _ := readTestObject(t, svc, "b", "moved/"+key); got != "data" {
+			t.Errorf("renamed copy of %q holds %q", key, got)
+		}
+
+		bucket, parsedKey, _ := parseCopySource(copySource("b", key, ""))
+		if bucket != "b" || parsedKey != key {
+			t.Errorf("copy source of %q parsed as %q, %q", key, bucket, parsedKey)
+		}
+	}
+}
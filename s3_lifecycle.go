@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// lifecycleRuleSpec describes a lifecycle rule as the rule builder asks for
+// it. Zero days leave the corresponding action out.
+type lifecycleRuleSpec struct {
+	id                   string
+	prefix               string
+	tags                 map[string]string
+	transitions          []lifecycleTransition
+	expireDays           int64
+	noncurrentExpireDays int64
+	abortIncompleteDays  int64
+	disabled             bool
+}
+
+// lifecycleTransition moves objects to storageClass days after creation.
+type lifecycleTransition struct {
+	days         int64
+	storageClass string
+}
+
+// getLifecycle returns the lifecycle rules of bucket, or none if it has no
+// lifecycle configuration.
+func getLifecycle(svc s3iface.S3API, bucket string) ([]*s3.LifecycleRule, error) {
+	result, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "NoSuchLifecycleConfiguration" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Rules, nil
+}
+
+// putLifecycle replaces the lifecycle configuration of bucket with rules.
+func putLifecycle(svc s3iface.S3API, bucket string, rules []*s3.LifecycleRule) error {
+	if len(rules) == 0 {
+		return errors.New("a lifecycle configuration needs at least one rule; delete it instead")
+	}
+	_, err := svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+func deleteLifecycle(svc s3iface.S3API, bucket string) error {
+	_, err := svc.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
+
+// newLifecycleRule builds a rule from spec. A rule needs at least one action,
+// and S3 does not allow aborting incomplete uploads in a rule filtered by
+// tags.
+func newLifecycleRule(spec lifecycleRuleSpec) (*s3.LifecycleRule, error) {
+	rule := &s3.LifecycleRule{
+		ID:     aws.String(spec.id),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: lifecycleFilter(spec.prefix, spec.tags),
+	}
+	if spec.disabled {
+		rule.Status = aws.String(s3.ExpirationStatusDisabled)
+	}
+	for _, t := range spec.transitions {
+		if t.days < 0 || !contains(s3.TransitionStorageClass_Values(), t.storageClass) {
+			return nil, fmt.Errorf("invalid transition %d:%s; storage classes are %s", t.days, t.storageClass, strings.Join(s3.TransitionStorageClass_Values(), ", "))
+		}
+		rule.Transitions = append(rule.Transitions, &s3.Transition{Days: aws.Int64(t.days), StorageClass: aws.String(t.storageClass)})
+	}
+	if spec.expireDays > 0 {
+		rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(spec.expireDays)}
+	}
+	if spec.noncurrentExpireDays > 0 {
+		rule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(spec.noncurrentExpireDays)}
+	}
+	if spec.abortIncompleteDays > 0 {
+		if len(spec.tags) > 0 {
+			return nil, errors.New("aborting incomplete multipart uploads cannot be combined with tag filters")
+		}
+		rule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(spec.abortIncompleteDays)}
+	}
+
+	if rule.Transitions == nil && rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+		return nil, errors.New("a lifecycle rule needs at least one transition or expiration")
+	}
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// lifecycleFilter returns the filter matching keys under prefix that carry
+// all of tags. S3 wants a single condition on its own and several combined
+// with And.
+func lifecycleFilter(prefix string, tags map[string]string) *s3.LifecycleRuleFilter {
+	if len(tags) == 0 {
+		return &s3.LifecycleRuleFilter{Prefix: aws.String(prefix)}
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var tagSet []*s3.Tag
+	for _, key := range keys {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	if prefix == "" && len(tagSet) == 1 {
+		return &s3.LifecycleRuleFilter{Tag: tagSet[0]}
+	}
+	and := &s3.LifecycleRuleAndOperator{Tags: tagSet}
+	if prefix != "" {
+		and.Prefix = aws.String(prefix)
+	}
+	return &s3.LifecycleRuleFilter{And: and}
+}
+
+// lifecyclePrefix returns the key prefix a rule applies to, wherever in the
+// rule it is set.
+func lifecyclePrefix(rule *s3.LifecycleRule) string {
+	switch {
+	case rule.Filter == nil:
+		return aws.StringValue(rule.Prefix)
+	case rule.Filter.And != nil:
+		return aws.StringValue(rule.Filter.And.Prefix)
+	default:
+		return aws.StringValue(rule.Filter.Prefix)
+	}
+}
+
+// lifecycleTags returns the tags a rule's objects must carry.
+func lifecycleTags(rule *s3.LifecycleRule) []*s3.Tag {
+	switch {
+	case rule.Filter == nil:
+		return nil
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Tags
+	case rule.Filter.Tag != nil:
+		return []*s3.Tag{rule.Filter.Tag}
+	default:
+		return nil
+	}
+}
+
+// parseTransitions reads transitions written as days:STORAGE_CLASS,
+// separated by commas.
+func parseTransitions(s string) ([]lifecycleTransition, error) {
+	var transitions []lifecycleTransition
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		days, class, ok := strings.Cut(item, ":")
+		n, err := strconv.ParseInt(strings.TrimSpace(days), 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid transition %q; use days:STORAGE_CLASS", item)
+		}
+		transitions = append(transitions, lifecycleTransition{days: n, storageClass: strings.ToUpper(strings.TrimSpace(class))})
+	}
+	return transitions, nil
+}
+
+// parseTags reads tags written as key=value, separated by commas.
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q; use key=value", item)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// marshalLifecycle writes rules as JSON in the form the AWS CLI uses for
+// lifecycle configurations, {"Rules": [...]}, leaving out unset fields.
+func marshalLifecycle(rules []*s3.LifecycleRule) ([]byte, error) {
+	data, err := json.Marshal(&s3.BucketLifecycleConfiguration{Rules: rules})
+	if err != nil {
+		return nil, err
+	}
+	// The SDK types have no omitempty tags, so drop the nulls afterwards.
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(dropNulls(doc), "", "  ")
+}
+
+func dropNulls(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+			} else {
+				v[key] = dropNulls(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = dropNulls(value)
+		}
+	}
+	return v
+}
+
+// saveLifecycle writes rules to a JSON file, or to standard output when the
+// name is "-".
+func saveLifecycle(rules []*s3.LifecycleRule, name string) error {
+	data, err := marshalLifecycle(rules)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if name == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+// loadLifecycle reads rules from a JSON file, or from standard input when the
+// name is "-".
+func loadLifecycle(name string) ([]*s3.LifecycleRule, error) {
+	data, err := readDocumentFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseLifecycle([]byte(data))
+}
+
+// parseLifecycle reads rules written by marshalLifecycle or the AWS CLI and
+// checks them before they are sent.
+func parseLifecycle(data []byte) ([]*s3.LifecycleRule, error) {
+	var config s3.BucketLifecycleConfiguration
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing lifecycle rules: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid lifecycle rules: %w", err)
+	}
+	return config.Rules, nil
+}
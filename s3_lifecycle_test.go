@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestNewLifecycleRule(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		spec lifecycleRuleSpec
+		ok   bool
+	}{
+		{"expiration", lifecycleRuleSpec{id: "r", prefix: "logs/", expireDays: 30}, true},
+		{"transitions and tags", lifecycleRuleSpec{id: "r", tags: map[string]string{"a": "1", "b": "2"}, transitions: []lifecycleTransition{{30, "STANDARD_IA"}, {90, "GLACIER"}}}, true},
+		{"no action", lifecycleRuleSpec{id: "r", prefix: "logs/"}, false},
+		{"unknown storage class", lifecycleRuleSpec{id: "r", transitions: []lifecycleTransition{{30, "COLD"}}}, false},
+		{"abort with tags", lifecycleRuleSpec{id: "r", tags: map[string]string{"a": "1"}, abortIncompleteDays: 7}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newLifecycleRule(tt.spec); (err == nil) != tt.ok {
+				t.Errorf("err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseTransitionsAndTags(t *testing.T) {
+	transitions, err := parseTransitions("30:standard_ia, 90:GLACIER")
+	if err != nil || len(transitions) != 2 || transitions[0] != (lifecycleTransition{30, "STANDARD_IA"}) {
+		t.Errorf("transitions = %v, %v", transitions, err)
+	}
+	if _, err := parseTransitions("30"); err == nil {
+		t.Error("parsed a transition without a storage class")
+	}
+	tags, err := parseTags("env=prod, team=")
+	if err != nil || len(tags) != 2 || tags["env"] != "prod" || tags["team"] != "" {
+		t.Errorf("tags = %v, %v", tags, err)
+	}
+	if _, err := parseTags("=x"); err == nil {
+		t.Error("parsed a tag without a key")
+	}
+}
+
+func TestLifecycleRoundTrip(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if rules, err := getLifecycle(svc, "b"); err != nil || rules != nil {
+		t.Fatalf("rules of a new bucket = %v, %v", rules, err)
+	}
+
+	expire, err := newLifecycleRule(lifecycleRuleSpec{id: "expire", prefix: "tmp/", expireDays: 7, abortIncompleteDays: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := newLifecycleRule(lifecycleRuleSpec{id: "archive", tags: map[string]string{"class": "cold"}, transitions: []lifecycleTransition{{30, "GLACIER"}}, disabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := putLifecycle(svc, "b", nil); err == nil {
+		t.Error("put an empty lifecycle configuration")
+	}
+	if err := putLifecycle(svc, "b", []*s3.LifecycleRule{expire, archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := getLifecycle(svc, "b")
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("rules = %v, %v", rules, err)
+	}
+	if lifecyclePrefix(rules[0]) != "tmp/" || aws.Int64Value(rules[0].Expiration.Days) != 7 {
+		t.Errorf("first rule = %v", rules[0])
+	}
+	if tags := lifecycleTags(rules[1]); len(tags) != 1 || aws.StringValue(rules[1].Status) != s3.ExpirationStatusDisabled {
+		t.Errorf("second rule = %v", rules[1])
+	}
+
+	data, err := marshalLifecycle(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseLifecycle(data)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", data, err)
+	}
+	if again, _ := marshalLifecycle(parsed); string(again) != string(data) {
+		t.Errorf("round trip changed the rules:\n%s\n%s", data, again)
+	}
+	if _, err := parseLifecycle([]byte(`{"Rules": [{"ID": "x"}]}`)); err == nil {
+		t.Error("parsed a rule without a status")
+	}
+
+	if err := deleteLifecycle(svc, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err := getLifecycle(svc, "b"); err != nil || rules != nil {
+		t.Errorf("rules after deleting = %v, %v", rules, err)
+	}
+}
@@ -1,121 +1,444 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-func createBucket(svc s3iface.S3API, bucket string) error {
-	_, err := svc.CreateBucket(&s3.CreateBucketInput{
-		Bucket: aws.String(bucket),
-	})
+// batchResult records the outcome of an operation that acts on several keys
+// and carries on past individual failures. add is safe for concurrent use.
+type batchResult struct {
+	mu        sync.Mutex
+	succeeded []string
+	skipped   []string
+	failed    []keyError
+}
+
+// keyError is the failure of a batch operation for a single key.
+type keyError struct {
+	key string
+	err error
+}
+
+func (e keyError) Error() string {
+	return e.key + ": " + e.err.Error()
+}
+
+func (e keyError) Unwrap() error {
+	return e.err
+}
+
+func (r *batchResult) add(key string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if err != nil {
-		fmt.Println("Error creating bucket:", err)
+		r.failed = append(r.failed, keyError{key: key, err: err})
+		return
+	}
+	r.succeeded = append(r.succeeded, key)
+}
+
+// skip records a key that was deliberately left alone.
+func (r *batchResult) skip(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped = append(r.skipped, key)
+}
+
+// merge adds the outcome of another batch to r.
+func (r *batchResult) merge(other *batchResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.succeeded = append(r.succeeded, other.succeeded...)
+	r.skipped = append(r.skipped, other.skipped...)
+	r.failed = append(r.failed, other.failed...)
+}
+
+// err returns a *batchError describing the failed keys, or nil if every key
+// succeeded.
+func (r *batchResult) err() error {
+	if len(r.failed) == 0 {
+		return nil
+	}
+	return &batchError{failed: r.failed}
+}
+
+// batchError is returned by batch operations when one or more keys failed.
+// The individual failures are also available from the batchResult.
+type batchError struct {
+	failed []keyError
+}
+
+func (e *batchError) Error() string {
+	msgs := make([]string, len(e.failed))
+	for i, failure := range e.failed {
+		msgs[i] = failure.Error()
+	}
+	return fmt.Sprintf("%d failed: %s", len(e.failed), strings.Join(msgs, "; "))
+}
+
+// maxDeleteKeys is the largest number of keys S3 accepts in a single
+// DeleteObjects request.
+const maxDeleteKeys = 1000
+
+// bucketListing is one bucket and its objects as returned by
+// listBucketsAndObjects. err is set if the objects could not be listed.
+type bucketListing struct {
+	name    string
+	objects []*s3.Object
+	err     error
+}
+
+// bucketOptions are the settings a bucket is created with. Zero values leave
+// the S3 defaults in place. The region is sent as the LocationConstraint,
+// which us-east-1 must not have.
+type bucketOptions struct {
+	region            string
+	objectLock        bool
+	ownership         string
+	acl               string
+	encryption        string
+	kmsKeyID          string
+	bucketKey         bool
+	versioning        bool
+	blockPublicAccess bool
+}
+
+var validOwnerships = []string{s3.ObjectOwnershipBucketOwnerEnforced, s3.ObjectOwnershipBucketOwnerPreferred, s3.ObjectOwnershipObjectWriter}
+
+var validEncryptions = []string{s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms}
+
+func (o *bucketOptions) addFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.objectLock, "object-lock", false, "enable object lock, which also enables versioning")
+	fs.StringVar(&o.ownership, "ownership", "", "object ownership: "+strings.Join(validOwnerships, ", "))
+	fs.StringVar(&o.acl, "acl", "", "canned ACL for the bucket")
+	fs.StringVar(&o.encryption, "encryption", "", "default encryption: "+strings.Join(validEncryptions, ", "))
+	fs.StringVar(&o.kmsKeyID, "kms-key-id", "", "KMS key for aws:kms default encryption (defaults to the AWS managed key)")
+	fs.BoolVar(&o.bucketKey, "bucket-key", false, "use an S3 bucket key with aws:kms default encryption")
+	fs.BoolVar(&o.versioning, "versioning", false, "enable versioning")
+	fs.BoolVar(&o.blockPublicAccess, "block-public-access", false, "block all public access")
+}
+
+func (o *bucketOptions) validate() error {
+	if o.acl != "" && !contains(validACLs, o.acl) {
+		return fmt.Errorf("invalid ACL %q; use one of %s", o.acl, strings.Join(validACLs, ", "))
+	}
+	if o.ownership != "" && !contains(validOwnerships, o.ownership) {
+		return fmt.Errorf("invalid object ownership %q; use one of %s", o.ownership, strings.Join(validOwnerships, ", "))
+	}
+	if o.ownership == s3.ObjectOwnershipBucketOwnerEnforced && o.acl != "" && o.acl != "private" && o.acl != "bucket-owner-full-control" {
+		return fmt.Errorf("ACLs are disabled with %s ownership, so the %s ACL cannot be set", o.ownership, o.acl)
+	}
+	if o.encryption != "" && !contains(validEncryptions, o.encryption) {
+		return fmt.Errorf("invalid encryption %q; use one of %s", o.encryption, strings.Join(validEncryptions, ", "))
+	}
+	if (o.kmsKeyID != "" || o.bucketKey) && o.encryption != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("a KMS key or bucket key needs %s encryption", s3.ServerSideEncryptionAwsKms)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// createBucket creates bucket and then applies the settings that cannot be
+// given at creation. If one of those fails, the bucket is left in place and
+// the error says which setting is missing.
+func createBucket(svc s3iface.S3API, bucket string, opts bucketOptions) error {
+	if err := opts.validate(); err != nil {
 		return err
 	}
-	fmt.Println("Bucket created successfully.")
+
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	if opts.region != "" && opts.region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(opts.region),
+		}
+	}
+	if opts.objectLock {
+		input.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+	if opts.ownership != "" {
+		input.ObjectOwnership = aws.String(opts.ownership)
+	}
+	if opts.acl != "" {
+		input.ACL = aws.String(opts.acl)
+	}
+	if _, err := svc.CreateBucket(input); err != nil {
+		return err
+	}
+
+	if opts.encryption != "" {
+		err := setBucketEncryption(svc, bucket, bucketEncryption{algorithm: opts.encryption, kmsKeyID: opts.kmsKeyID, bucketKey: opts.bucketKey})
+		if err != nil {
+			return fmt.Errorf("bucket created, but setting default encryption failed: %w", err)
+		}
+	}
+	// Object lock turns versioning on by itself.
+	if opts.versioning && !opts.objectLock {
+		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+		})
+		if err != nil {
+			return fmt.Errorf("bucket created, but enabling versioning failed: %w", err)
+		}
+	}
+	if opts.blockPublicAccess {
+		_, err := svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucket),
+			PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("bucket created, but blocking public access failed: %w", err)
+		}
+	}
 	return nil
 }
 
-func createFolder(svc *s3.S3, bucket, folder string) {
+func createFolder(svc s3iface.S3API, bucket, folder string) error {
 	_, err := svc.PutObject(&s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(folder + "/"),
 	})
-	if err != nil {
-		fmt.Println("Error creating folder:", err)
-		return
+	return err
+}
+
+// uploadSingleFile uploads a file to key, as a multipart upload when it is
+// larger than the configured part size.
+func uploadSingleFile(svc s3iface.S3API, bucket, filePath, key string, opts transferOptions, progress *transferProgress) error {
+	progress.expect(1, -1)
+	return uploadFile(newUploader(svc, opts), bucket, filePath, key, opts.cse.master, progress)
+}
+
+// uploadMultipleFiles uploads each file under prefix, keyed by its base name.
+func uploadMultipleFiles(svc s3iface.S3API, bucket string, filePaths []string, prefix string, opts transferOptions, progress *transferProgress) (*batchResult, error) {
+	files := make([]fileUpload, len(filePaths))
+	for i, path := range filePaths {
+		files[i] = fileUpload{path: path, key: joinKey(prefix, filepath.Base(path))}
 	}
-	fmt.Println("Folder created successfully.")
+	res := uploadFiles(svc, bucket, files, opts, progress)
+	return res, res.err()
 }
 
-func uploadSingleFile(svc *s3.S3, bucket, filePath string) {
-	file, err := os.Open(filePath)
+// directoryUploadOptions selects which files uploadDirectory sends.
+//
+// Patterns use path.Match syntax. A pattern containing a slash is matched
+// against the slash-separated path relative to the uploaded directory, any
+// other pattern against the base name. Excluded directories are skipped
+// entirely; include patterns only apply to files.
+type directoryUploadOptions struct {
+	include        []string
+	exclude        []string
+	followSymlinks bool
+}
+
+// uploadDirectory uploads every file in the tree rooted at localDir, using
+// each file's path relative to localDir as its key under prefix. Symbolic
+// links are skipped unless followSymlinks is set.
+func uploadDirectory(svc s3iface.S3API, bucket, localDir, prefix string, opts directoryUploadOptions, transfer transferOptions, progress *transferProgress) (*batchResult, error) {
+	var files []fileUpload
+	err := walkLocalFiles(localDir, opts, func(filePath, relPath string) {
+		files = append(files, fileUpload{path: filePath, key: joinKey(prefix, relPath)})
+	})
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		return &batchResult{}, err
 	}
-	defer file.Close()
+	res := uploadFiles(svc, bucket, files, transfer, progress)
+	return res, res.err()
+}
 
-	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(filePath),
-		Body:   file,
-	})
+// walkLocalFiles calls fn with the path and slash-separated relative path of
+// every file under root that opts selects.
+func walkLocalFiles(root string, opts directoryUploadOptions, fn func(filePath, relPath string)) error {
+	info, err := os.Stat(root)
 	if err != nil {
-		fmt.Println("Error uploading file:", err)
-		return
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+
+	visited := make(map[string]bool)
+	var walk func(dir, relDir string) error
+	walk = func(dir, relDir string) error {
+		// Remember each real directory so that symlink cycles end.
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if visited[real] {
+				return nil
+			}
+			visited[real] = true
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			filePath := filepath.Join(dir, entry.Name())
+			relPath := path.Join(relDir, entry.Name())
+
+			mode := entry.Type()
+			if mode&fs.ModeSymlink != 0 {
+				if !opts.followSymlinks {
+					continue
+				}
+				target, err := os.Stat(filePath)
+				if err != nil {
+					return err
+				}
+				mode = target.Mode().Type()
+			}
+
+			if matchesAny(opts.exclude, relPath) {
+				continue
+			}
+			switch {
+			case mode.IsDir():
+				if err := walk(filePath, relPath); err != nil {
+					return err
+				}
+			case mode.IsRegular():
+				if len(opts.include) == 0 || matchesAny(opts.include, relPath) {
+					fn(filePath, relPath)
+				}
+			}
+		}
+		return nil
 	}
-	fmt.Println("File uploaded successfully.")
+	return walk(root, "")
 }
 
-func uploadMultipleFiles(svc *s3.S3, bucket, filePaths string) {
-	paths := strings.Split(filePaths, ",")
-	for _, path := range paths {
-		uploadSingleFile(svc, bucket, strings.TrimSpace(path))
+// matchesAny reports whether relPath matches one of the patterns, as
+// described on directoryUploadOptions.
+func matchesAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := path.Base(relPath)
+		if strings.Contains(pattern, "/") {
+			name = relPath
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// joinKey appends name to a key prefix, adding a slash separator when the
+// prefix does not already end with one.
+func joinKey(prefix, name string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + name
 	}
+	return prefix + "/" + name
 }
 
-func deleteSingleFile(svc *s3.S3, bucket, fileKey string) {
+func deleteSingleFile(svc s3iface.S3API, bucket, fileKey string) error {
 	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(fileKey),
 	})
-	if err != nil {
-		fmt.Println("Error deleting file:", err)
-		return
-	}
-	fmt.Println("File deleted successfully.")
+	return err
 }
 
-func deleteMultipleFiles(svc *s3.S3, bucket, fileKeys string) {
-	keys := strings.Split(fileKeys, ",")
-	objects := make([]*s3.ObjectIdentifier, len(keys))
-	for i, key := range keys {
-		objects[i] = &s3.ObjectIdentifier{Key: aws.String(strings.TrimSpace(key))}
+func deleteMultipleFiles(svc s3iface.S3API, bucket string, fileKeys []string) (*batchResult, error) {
+	objects := make([]*s3.ObjectIdentifier, len(fileKeys))
+	for i, key := range fileKeys {
+		objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
 	}
 
-	_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
-		Bucket: aws.String(bucket),
-		Delete: &s3.Delete{Objects: objects},
-	})
-	if err != nil {
-		fmt.Println("Error deleting files:", err)
-		return
+	res := &batchResult{}
+	if err := deleteObjects(svc, bucket, objects, res); err != nil {
+		return res, err
 	}
-	fmt.Println("Files deleted successfully.")
+	return res, res.err()
 }
 
-func deleteFolder(svc *s3.S3, bucket, folder string) {
-	resp, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+// deleteObjects removes objects with DeleteObjects calls of at most
+// maxDeleteKeys keys each and records the per-key outcome in res.
+func deleteObjects(svc s3iface.S3API, bucket string, objects []*s3.ObjectIdentifier, res *batchResult) error {
+	for start := 0; start < len(objects); start += maxDeleteKeys {
+		end := start + maxDeleteKeys
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		resp, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects[start:end]},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, deleted := range resp.Deleted {
+			res.add(aws.StringValue(deleted.Key), nil)
+		}
+		for _, failure := range resp.Errors {
+			res.add(aws.StringValue(failure.Key), fmt.Errorf("%s: %s", aws.StringValue(failure.Code), aws.StringValue(failure.Message)))
+		}
+	}
+	return nil
+}
+
+// forEachObjectPage calls fn with every page of objects under prefix,
+// following continuation tokens until the listing is exhausted or fn returns
+// an error.
+func forEachObjectPage(svc s3iface.S3API, bucket, prefix string, fn func(objects []*s3.Object) error) error {
+	var fnErr error
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(folder + "/"),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		fnErr = fn(page.Contents)
+		return fnErr == nil
 	})
 	if err != nil {
-		fmt.Println("Error listing objects:", err)
-		return
-	}
-
-	objects := make([]*s3.ObjectIdentifier, len(resp.Contents))
-	for i, item := range resp.Contents {
-		objects[i] = &s3.ObjectIdentifier{Key: item.Key}
+		return fmt.Errorf("listing objects: %w", err)
 	}
+	return fnErr
+}
 
-	if len(objects) > 0 {
-		_, err = svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(bucket),
-			Delete: &s3.Delete{Objects: objects},
-		})
-		if err != nil {
-			fmt.Println("Error deleting objects:", err)
-			return
+func deleteFolder(svc s3iface.S3API, bucket, folder string) (*batchResult, error) {
+	res := &batchResult{}
+	err := forEachObjectPage(svc, bucket, folder+"/", func(page []*s3.Object) error {
+		objects := make([]*s3.ObjectIdentifier, len(page))
+		for i, item := range page {
+			objects[i] = &s3.ObjectIdentifier{Key: item.Key}
 		}
+		if err := deleteObjects(svc, bucket, objects, res); err != nil {
+			return fmt.Errorf("deleting objects: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
 	}
 
 	// Delete the folder itself (represented as an object with a trailing slash)
@@ -124,66 +447,353 @@ func deleteFolder(svc *s3.S3, bucket, folder string) {
 		Key:    aws.String(folder + "/"),
 	})
 	if err != nil {
-		fmt.Println("Error deleting folder:", err)
-		return
+		return res, fmt.Errorf("deleting folder marker: %w", err)
 	}
 
-	fmt.Println("Folder deleted successfully.")
+	return res, res.err()
 }
 
-func listBucketsAndObjects(svc *s3.S3) {
+func listBucketsAndObjects(svc s3iface.S3API) ([]bucketListing, error) {
 	result, err := svc.ListBuckets(nil)
 	if err != nil {
-		fmt.Println("Error listing buckets:", err)
-		return
+		return nil, fmt.Errorf("listing buckets: %w", err)
 	}
 
-	fmt.Println("Buckets:")
-	for _, b := range result.Buckets {
-		fmt.Printf("* %s\n", aws.StringValue(b.Name))
+	var errs []error
+	listings := make([]bucketListing, len(result.Buckets))
+	for i, b := range result.Buckets {
+		listings[i].name = aws.StringValue(b.Name)
 
-		resp, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: b.Name})
+		objects, err := listObjects(svc, listings[i].name, "")
 		if err != nil {
-			fmt.Println("Error listing objects:", err)
+			listings[i].err = err
+			errs = append(errs, fmt.Errorf("listing objects in %s: %w", listings[i].name, err))
 			continue
 		}
+		listings[i].objects = objects
+	}
+	return listings, errors.Join(errs...)
+}
+
+// listBucketNames returns the names of the buckets that contain filter,
+// ignoring case. An empty filter matches every bucket.
+func listBucketNames(svc s3iface.S3API, filter string) ([]string, error) {
+	result, err := svc.ListBuckets(nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing buckets: %w", err)
+	}
 
-		fmt.Println("  Objects:")
-		for _, item := range resp.Contents {
-			fmt.Printf("    - %s\n", aws.StringValue(item.Key))
+	filter = strings.ToLower(filter)
+	var names []string
+	for _, b := range result.Buckets {
+		name := aws.StringValue(b.Name)
+		if strings.Contains(strings.ToLower(name), filter) {
+			names = append(names, name)
 		}
 	}
+	return names, nil
 }
 
-func downloadSingleFile(svc *s3.S3, bucket, fileKey, destinationPath string) {
-	output, err := svc.GetObject(&s3.GetObjectInput{
+func listObjects(svc s3iface.S3API, bucket, prefix string) ([]*s3.Object, error) {
+	var objects []*s3.Object
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(fileKey),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
 	})
 	if err != nil {
-		fmt.Println("Error downloading file:", err)
-		return
+		return nil, err
+	}
+	return objects, nil
+}
+
+// copyObjectVersion copies the given version of the source object, or the
+// latest one if versionID is empty.
+func copyObjectVersion(svc s3iface.S3API, sourceBucket, sourceKey, versionID, destinationBucket, destinationKey string) error {
+	if versionID == "" {
+		return copyObject(svc, sourceBucket, sourceKey, destinationBucket, destinationKey)
+	}
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(destinationBucket),
+		CopySource: aws.String(copySource(sourceBucket, sourceKey, versionID)),
+		Key:        aws.String(destinationKey),
+	})
+	return err
+}
+
+func copyObject(svc s3iface.S3API, sourceBucket, sourceKey, destinationBucket, destinationKey string) error {
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(destinationBucket),
+		CopySource: aws.String(copySource(sourceBucket, sourceKey, "")),
+		Key:        aws.String(destinationKey),
+	})
+	return err
+}
+
+// copySource returns the CopySource header naming the given version of an
+// object, or its latest version if versionID is empty. S3 expects the header
+// URL-encoded, so each segment of the key is escaped, including any "?" or
+// "+" that S3 would otherwise take for a query or a space.
+func copySource(bucket, key, versionID string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	source := url.PathEscape(bucket) + "/" + strings.Join(segments, "/")
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return source
+}
+
+// parseCopySource splits a CopySource header into the bucket, key and
+// version it names.
+func parseCopySource(source string) (bucket, key, versionID string) {
+	source, query, _ := strings.Cut(strings.TrimPrefix(source, "/"), "?")
+	if values, err := url.ParseQuery(query); err == nil {
+		versionID = values.Get("versionId")
+	}
+	if unescaped, err := url.PathUnescape(source); err == nil {
+		source = unescaped
+	}
+	bucket, key, _ = strings.Cut(source, "/")
+	return bucket, key, versionID
+}
+
+// downloadSingleFile downloads key to destinationPath, resuming an earlier
+// interrupted download of the same object if one is found.
+func downloadSingleFile(svc s3iface.S3API, bucket, fileKey, destinationPath string, opts transferOptions, progress *transferProgress) error {
+	progress.expect(1, -1)
+	return downloadFile(svc, bucket, fileKey, "", destinationPath, opts, progress)
+}
+
+func downloadMultipleFiles(svc s3iface.S3API, bucket string, fileKeysAndPaths map[string]string, opts transferOptions, progress *transferProgress) (*batchResult, error) {
+	files := make([]fileDownload, 0, len(fileKeysAndPaths))
+	for fileKey, destinationPath := range fileKeysAndPaths {
+		files = append(files, fileDownload{key: fileKey, path: destinationPath})
 	}
-	defer output.Body.Close()
+	sort.Slice(files, func(i, j int) bool { return files[i].key < files[j].key })
 
-	file, err := os.Create(destinationPath)
+	res := downloadFiles(svc, bucket, files, opts, progress)
+	return res, res.err()
+}
+
+// conflictPolicy says what downloadDirectory does when a file it is about to
+// write already exists.
+type conflictPolicy string
+
+const (
+	conflictSkip      conflictPolicy = "skip"
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictRename    conflictPolicy = "rename"
+)
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(s); p {
+	case conflictSkip, conflictOverwrite, conflictRename:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q; use skip, overwrite or rename", s)
+}
+
+// downloadDirectory downloads every object under prefix into localDir,
+// recreating the folders of the keys below prefix as local directories.
+// Existing files are skipped, overwritten or kept alongside a renamed copy
+// of the download, depending on conflict.
+func downloadDirectory(svc s3iface.S3API, bucket, prefix, localDir string, conflict conflictPolicy, opts transferOptions, progress *transferProgress) (*batchResult, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	res := &batchResult{}
+	var files []fileDownload
+	claimed := make(map[string]bool)
+	err := forEachObjectPage(svc, bucket, prefix, func(page []*s3.Object) error {
+		for _, item := range page {
+			key := aws.StringValue(item.Key)
+			relPath := strings.TrimPrefix(key, prefix)
+			if relPath == "" {
+				continue
+			}
+			// Keys are not file names: refuse anything that would land
+			// outside localDir.
+			if !filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(relPath, "/"))) {
+				res.add(key, errors.New("key does not map to a path inside the destination folder"))
+				continue
+			}
+			destinationPath := filepath.Join(localDir, filepath.FromSlash(relPath))
+
+			// Folder markers only need their directory.
+			if strings.HasSuffix(key, "/") {
+				if err := os.MkdirAll(destinationPath, 0o755); err != nil {
+					res.add(key, err)
+				}
+				continue
+			}
+
+			if fileExists(destinationPath) || claimed[destinationPath] {
+				switch conflict {
+				case conflictSkip:
+					res.skip(key)
+					continue
+				case conflictRename:
+					destinationPath = freePath(destinationPath, claimed)
+				}
+			}
+			claimed[destinationPath] = true
+			files = append(files, fileDownload{key: key, path: destinationPath})
+		}
+		return nil
+	})
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return
+		return res, err
+	}
+
+	res.merge(downloadFiles(svc, bucket, files, opts, progress))
+	return res, res.err()
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Lstat(filePath)
+	return err == nil
+}
+
+// freePath returns the first of "name (1).ext", "name (2).ext", ... that
+// neither exists nor has been claimed.
+func freePath(filePath string, claimed map[string]bool) string {
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !fileExists(candidate) && !claimed[candidate] {
+			return candidate
+		}
+	}
+}
+
+// emptyBucket removes everything that stops a bucket from being deleted:
+// every object version and delete marker (which includes every object of an
+// unversioned bucket) and every multipart upload in progress. Versions are
+// deleted in batches of maxDeleteKeys, with up to workers batches or aborts
+// in flight at once, and progress is called with the running total of
+// removed entries.
+func emptyBucket(svc s3iface.S3API, bucket string, workers int, progress func(removed int)) (*batchResult, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	res := &batchResult{}
+
+	var mu sync.Mutex
+	removed := 0
+	report := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		removed += n
+		if progress != nil {
+			progress(removed)
+		}
+	}
+
+	var errs []error
+	batches := make(chan []*s3.ObjectIdentifier)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batchRes := &batchResult{}
+				if err := deleteObjects(svc, bucket, batch, batchRes); err != nil {
+					for _, object := range batch {
+						batchRes.add(aws.StringValue(object.Key), err)
+					}
+				}
+				res.merge(batchRes)
+				report(len(batchRes.succeeded))
+			}
+		}()
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, output.Body)
+	var pending []*s3.ObjectIdentifier
+	err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			pending = append(pending, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range page.DeleteMarkers {
+			pending = append(pending, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		for len(pending) >= maxDeleteKeys || (lastPage && len(pending) > 0) {
+			n := len(pending)
+			if n > maxDeleteKeys {
+				n = maxDeleteKeys
+			}
+			batches <- pending[:n]
+			pending = pending[n:]
+		}
+		return true
+	})
+	close(batches)
+	wg.Wait()
 	if err != nil {
-		fmt.Println("Error writing to file:", err)
-		return
+		errs = append(errs, fmt.Errorf("listing object versions: %w", err))
 	}
 
-	fmt.Println("File downloaded successfully.")
+	var uploads []*s3.MultipartUpload
+	err = svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		uploads = append(uploads, page.Uploads...)
+		return true
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing multipart uploads: %w", err))
+	}
+	parallel(len(uploads), workers, func(i int) {
+		_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      uploads[i].Key,
+			UploadId: uploads[i].UploadId,
+		})
+		res.add(aws.StringValue(uploads[i].Key)+" (upload "+aws.StringValue(uploads[i].UploadId)+")", err)
+		if err == nil {
+			report(1)
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return res, err
+	}
+	return res, res.err()
 }
 
-func downloadMultipleFiles(svc *s3.S3, bucket string, fileKeysAndPaths map[string]string) {
-	for fileKey, destinationPath := range fileKeysAndPaths {
-		downloadSingleFile(svc, bucket, fileKey, destinationPath)
+// bucketContents counts everything emptyBucket would remove: object versions
+// and delete markers with their total size, and multipart uploads.
+func bucketContents(svc s3iface.S3API, bucket string) (versions int, size int64, uploads int, err error) {
+	err = svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			versions++
+			size += aws.Int64Value(version.Size)
+		}
+		versions += len(page.DeleteMarkers)
+		return true
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("listing object versions: %w", err)
+	}
+	err = svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		uploads += len(page.Uploads)
+		return true
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("listing multipart uploads: %w", err)
 	}
+	return versions, size, uploads, nil
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadDirectory(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.txt":       "a",
+		"sub/b.txt":   "b",
+		"sub/c.log":   "c",
+		".git/config": "x",
+	})
+	if err := os.Symlink(filepath.Join(dir, "a.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := directoryUploadOptions{include: []string{"*.txt"}, exclude: []string{".git"}}
+	res, err := uploadDirectory(svc, "b", dir, "up", opts, testTransfer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.succeeded) != 2 {
+		t.Errorf("uploaded %d files, want 2", len(res.succeeded))
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"up/a.txt", "up/sub/b.txt"}) {
+		t.Errorf("keys = %v", keys)
+	}
+
+	opts.followSymlinks = true
+	if _, err := uploadDirectory(svc, "b", dir, "follow/", opts, testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readTestObject(t, svc, "b", "follow/link.txt"); got != "a" {
+		t.Errorf("followed symlink holds %q", got)
+	}
+}
+
+func TestDownloadDirectory(t *testing.T) {
+	for _, tt := range []struct {
+		conflict conflictPolicy
+		want     map[string]string
+	}{
+		{conflictOverwrite, map[string]string{"a.txt": "new", "sub/b.txt": "b"}},
+		{conflictSkip, map[string]string{"a.txt": "old", "sub/b.txt": "b"}},
+		{conflictRename, map[string]string{"a.txt": "old", "a (1).txt": "new", "sub/b.txt": "b"}},
+	} {
+		t.Run(fmt.Sprint(tt.conflict), func(t *testing.T) {
+			f, svc, _ := newTestFake(t, "b")
+			putTestObject(t, svc, "b", "data/a.txt", "new")
+			putTestObject(t, svc, "b", "data/sub/b.txt", "b")
+			putTestObject(t, svc, "b", "data/empty/", "")
+			// The SDK cleans ".." out of request paths, so such a key can
+			// only be planted directly.
+			f.addVersion(f.buckets["b"], "data/../escape", &fakeVersion{data: []byte("x"), etag: md5ETag([]byte("x"))})
+
+			dir := t.TempDir()
+			writeTestFiles(t, dir, map[string]string{"a.txt": "old"})
+			res, err := downloadDirectory(svc, "b", "data", dir, tt.conflict, testTransfer, nil)
+			if err == nil || len(res.failed) != 1 || res.failed[0].key != "data/../escape" {
+				t.Errorf("want only the escaping key to fail, got %v", err)
+			}
+			for name, body := range tt.want {
+				if got := readTestFile(t, filepath.Join(dir, filepath.FromSlash(name))); got != body {
+					t.Errorf("%s = %q, want %q", name, got, body)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(dir, "empty")); err != nil || !info.IsDir() {
+				t.Errorf("folder marker did not become a folder: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteFolder(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	for _, key := range []string{"dir/", "dir/a", "dir/sub/b", "dirt", "other/c"} {
+		putTestObject(t, svc, "b", key, "x")
+	}
+	res, err := deleteFolder(svc, "b", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.succeeded) != 3 {
+		t.Errorf("deleted %d objects, want 3", len(res.succeeded))
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"dirt", "other/c"}) {
+		t.Errorf("keys left = %v", keys)
+	}
+}
+
+func TestDeleteMultipleFilesBatches(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	var keys []string
+	for i := 0; i < maxDeleteKeys+5; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		putTestObject(t, svc, "b", key, "")
+		keys = append(keys, key)
+	}
+	rec.take()
+	res, err := deleteMultipleFiles(svc, "b", keys)
+	if err != nil || len(res.succeeded) != len(keys) {
+		t.Fatalf("deleted %d of %d: %v", len(res.succeeded), len(keys), err)
+	}
+	if n := len(rec.take()); n != 2 {
+		t.Errorf("made %d requests, want 2 batches", n)
+	}
+	if left := testKeys(t, svc, "b", ""); len(left) != 0 {
+		t.Errorf("keys left = %v", left)
+	}
+}
+
+func TestMoveFolders(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	putTestObject(t, svc, "b", "from/a", "a")
+	putTestObject(t, svc, "b", "from/sub/b", "b")
+
+	res, err := moveFolders(svc, "b", []string{"from", "x"}, []string{"to", "x/inside"}, nil)
+	if err == nil || len(res.failed) != 1 {
+		t.Errorf("want the move into itself to fail, got %v", err)
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"to/a", "to/sub/b"}) {
+		t.Errorf("keys = %v", keys)
+	}
+}
+
+func TestEmptyAndDeleteBucket(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if err := setVersioning(svc, "b", true); err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, svc, "b", "a", "1")
+	putTestObject(t, svc, "b", "a", "2")
+	if err := deleteSingleFile(svc, "b", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, _, _, err := bucketContents(svc, "b")
+	if err != nil || versions != 3 {
+		t.Fatalf("bucket holds %d versions (%v), want 3", versions, err)
+	}
+	if err := deleteBucket(svc, "b"); err == nil {
+		t.Fatal("deleted a bucket that is not empty")
+	}
+	if _, err := emptyBucket(svc, "b", 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteBucket(svc, "b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPresignURLWithoutShortening(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	urlStr, err := presignURL(svc, "b", "dir/a b.txt", 30, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(urlStr, "/b/dir/a%20b.txt?") || !strings.Contains(urlStr, "X-Amz-Expires=1800") {
+		t.Errorf("URL = %s", urlStr)
+	}
+	if requests := rec.take(); len(requests) != 0 {
+		t.Errorf("presigning made %d requests", len(requests))
+	}
+}
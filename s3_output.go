@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// errorOutput receives the error messages of operations: standard output in
+// the interactive menu, where they are part of the conversation, and
+// standard error for subcommands, so that they stay out of the output
+// scripts read.
+var errorOutput io.Writer = os.Stdout
+
+// report prints the outcome of an operation that succeeds or fails as a
+// whole and returns err unchanged, so callers can pass it on.
+func report(err error, failure, success string) error {
+	if err != nil {
+		fmt.Fprintln(errorOutput, failure+":", err)
+		return err
+	}
+	fmt.Println(success)
+	return nil
+}
+
+// reportBatch prints every failed key of a batch operation, any error that
+// stopped the operation early, a success line when at least one key
+// succeeded and the number of skipped keys. success may contain a %d verb
+// for the number of keys.
+func reportBatch(res *batchResult, err error, failure, success string) error {
+	if res != nil {
+		for _, f := range res.failed {
+			fmt.Fprintf(errorOutput, "%s %s: %v\n", failure, f.key, f.err)
+		}
+	}
+
+	var berr *batchError
+	if err != nil && !errors.As(err, &berr) {
+		fmt.Fprintln(errorOutput, failure+":", err)
+	}
+
+	if res != nil && (len(res.succeeded) > 0 || err == nil) {
+		fmt.Printf(success+"\n", len(res.succeeded))
+	}
+	if res != nil && len(res.skipped) > 0 {
+		fmt.Printf("%d skipped.\n", len(res.skipped))
+	}
+	return err
+}
+
+func printBucketListings(listings []bucketListing) {
+	fmt.Println("Buckets:")
+	for _, listing := range listings {
+		fmt.Printf("* %s\n", listing.name)
+		if listing.err != nil {
+			fmt.Fprintln(errorOutput, "Error listing objects:", listing.err)
+			continue
+		}
+
+		fmt.Println("  Objects:")
+		for _, item := range listing.objects {
+			fmt.Printf("    - %s\n", aws.StringValue(item.Key))
+		}
+	}
+}
+
+func printBucketNames(names []string, active string) {
+	for i, name := range names {
+		if name == active {
+			fmt.Printf("%3d. %s (active)\n", i+1, name)
+		} else {
+			fmt.Printf("%3d. %s\n", i+1, name)
+		}
+	}
+}
+
+func printObjects(objects []*s3.Object) {
+	for _, item := range objects {
+		fmt.Printf("%s  %12d  %s\n", aws.TimeValue(item.LastModified).Format("2006-01-02 15:04:05"), aws.Int64Value(item.Size), aws.StringValue(item.Key))
+	}
+}
+
+func printBucketInfo(info *bucketInfo) {
+	fmt.Printf("Bucket: %s\n", info.name)
+	fmt.Printf("Location: %s\n", info.location)
+}
+
+func printObjectInfo(info *objectInfo) {
+	fmt.Printf("Object Key: %s\n", info.key)
+	fmt.Printf("Size: %d bytes\n", info.size)
+	fmt.Printf("Last Modified: %s\n", info.lastModified)
+	fmt.Printf("Content Type: %s\n", info.contentType)
+	if info.encryption != "" {
+		fmt.Printf("Encryption: %s\n", info.encryption)
+	}
+}
+
+func printBucketEncryption(enc *bucketEncryption) {
+	if enc == nil {
+		fmt.Println("Default encryption: none configured (S3 encrypts new objects with AES256)")
+		return
+	}
+	fmt.Printf("Default encryption: %s\n", enc.algorithm)
+	if enc.kmsKeyID != "" {
+		fmt.Printf("KMS key: %s\n", enc.kmsKeyID)
+	}
+	if enc.bucketKey {
+		fmt.Println("Bucket key: enabled")
+	}
+}
+
+func printVersioning(status string) {
+	if status == "" {
+		status = "Never enabled"
+	}
+	fmt.Printf("Versioning: %s\n", status)
+}
+
+// printVersions lists object versions and delete markers, one per line,
+// newest first for each key.
+func printVersions(versions []objectVersion) {
+	if len(versions) == 0 {
+		fmt.Println("No versions found.")
+		return
+	}
+	for _, v := range versions {
+		size := fmt.Sprintf("%12d", v.size)
+		var flags []string
+		if v.deleteMarker {
+			size = fmt.Sprintf("%12s", "-")
+			flags = append(flags, "delete marker")
+		}
+		if v.latest {
+			flags = append(flags, "latest")
+		}
+		line := fmt.Sprintf("%s  %s  %-32s  %s", v.lastModified.Format("2006-01-02 15:04:05"), size, v.versionID, v.key)
+		if len(flags) > 0 {
+			line += " (" + strings.Join(flags, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// printLifecycle describes each lifecycle rule with its filter and actions,
+// numbered so that the rule builder can refer to them.
+func printLifecycle(rules []*s3.LifecycleRule) {
+	if len(rules) == 0 {
+		fmt.Println("No lifecycle rules.")
+		return
+	}
+	for i, rule := range rules {
+		fmt.Printf("%d. %s (%s)\n", i+1, aws.StringValue(rule.ID), aws.StringValue(rule.Status))
+
+		filter := fmt.Sprintf("prefix %q", lifecyclePrefix(rule))
+		for _, tag := range lifecycleTags(rule) {
+			filter += fmt.Sprintf(", tag %s=%s", aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		}
+		fmt.Println("   Applies to:", filter)
+
+		for _, t := range rule.Transitions {
+			fmt.Printf("   Move to %s %s\n", aws.StringValue(t.StorageClass), lifecycleWhen(t.Days, t.Date))
+		}
+		if e := rule.Expiration; e != nil {
+			if aws.BoolValue(e.ExpiredObjectDeleteMarker) {
+				fmt.Println("   Remove expired delete markers")
+			}
+			if e.Days != nil || e.Date != nil {
+				fmt.Println("   Expire", lifecycleWhen(e.Days, e.Date))
+			}
+		}
+		for _, t := range rule.NoncurrentVersionTransitions {
+			fmt.Printf("   Move noncurrent versions to %s %d days after they become noncurrent\n", aws.StringValue(t.StorageClass), aws.Int64Value(t.NoncurrentDays))
+		}
+		if e := rule.NoncurrentVersionExpiration; e != nil {
+			fmt.Printf("   Expire noncurrent versions %d days after they become noncurrent\n", aws.Int64Value(e.NoncurrentDays))
+		}
+		if a := rule.AbortIncompleteMultipartUpload; a != nil {
+			fmt.Printf("   Abort incomplete multipart uploads after %d days\n", aws.Int64Value(a.DaysAfterInitiation))
+		}
+	}
+}
+
+func lifecycleWhen(days *int64, date *time.Time) string {
+	if date != nil {
+		return "on " + date.UTC().Format("2006-01-02")
+	}
+	return fmt.Sprintf("after %d days", aws.Int64Value(days))
+}
+
+// printSyncPlan lists the changes of a sync followed by a summary with the
+// number of files and bytes per kind of change.
+func printSyncPlan(plan *syncPlan) {
+	fmt.Printf("Sync %s -> %s\n", plan.source, plan.target)
+
+	var kinds []string
+	var sizes []int64
+	for _, action := range plan.actions {
+		fmt.Printf("  %-8s %s (%d bytes, %s)\n", action.kind, action.name, action.size, action.reason)
+		kinds = append(kinds, action.kind)
+		sizes = append(sizes, action.size)
+	}
+
+	printPlanSummary(kinds, sizes, "%d to %s (%d bytes)\n")
+	fmt.Printf("%d unchanged\n", plan.unchanged)
+}
+
+// printRestorePlan lists the changes of a point-in-time restore, followed by
+// the number of keys and bytes per kind of change.
+func printRestorePlan(plan *restorePlan) {
+	fmt.Printf("Restore s3://%s/%s to %s\n", plan.bucket, plan.target, plan.at.UTC().Format("2006-01-02 15:04:05 MST"))
+
+	var kinds []string
+	var sizes []int64
+	for _, action := range plan.actions {
+		if action.kind == "restore" {
+			fmt.Printf("  %-8s %s (version %s, %d bytes)\n", action.kind, action.key, action.versionID, action.size)
+		} else {
+			fmt.Printf("  %-8s %s (did not exist then, %d bytes)\n", action.kind, action.key, action.size)
+		}
+		kinds = append(kinds, action.kind)
+		sizes = append(sizes, action.size)
+	}
+
+	printPlanSummary(kinds, sizes, "%d to %s (%d bytes)\n")
+	fmt.Printf("%d unchanged\n", plan.unchanged)
+}
+
+// printDryRun lists the writes an action would have made, followed by the
+// number of changes and bytes per kind of write.
+func printDryRun(changes []dryRunChange) {
+	fmt.Println("Dry run: nothing was changed. These changes would have been made:")
+
+	var operations []string
+	var sizes []int64
+	var total int64
+	for _, change := range changes {
+		line := fmt.Sprintf("  %-14s %s", change.operation, change.target)
+		if change.detail != "" {
+			line += " " + change.detail
+		}
+		if change.size > 0 {
+			line += fmt.Sprintf(" (%d bytes)", change.size)
+		}
+		fmt.Println(line)
+
+		operations = append(operations, change.operation)
+		sizes = append(sizes, change.size)
+		total += change.size
+	}
+
+	printPlanSummary(operations, sizes, "%d %s (%d bytes)\n")
+	fmt.Printf("%d changes, %d bytes in total\n", len(changes), total)
+}
+
+// printPlanSummary prints a line per kind of change with the number of
+// changes of that kind and their bytes, in the order the kinds first appear.
+// kinds and sizes describe one change each, and format takes the count, the
+// kind and the bytes.
+func printPlanSummary(kinds []string, sizes []int64, format string) {
+	counts := make(map[string]int)
+	bytes := make(map[string]int64)
+	var order []string
+	for i, kind := range kinds {
+		if counts[kind] == 0 {
+			order = append(order, kind)
+		}
+		counts[kind]++
+		bytes[kind] += sizes[i]
+	}
+	for _, kind := range order {
+		fmt.Printf(format, counts[kind], kind, bytes[kind])
+	}
+}
+
+// printTransferSummary prints a table of the files of a batch transfer with
+// their size, time, throughput and outcome, followed by the totals.
+func printTransferSummary(progress *transferProgress) {
+	if progress == nil {
+		return
+	}
+	summary := progress.summary()
+	if len(summary.files) == 0 {
+		return
+	}
+
+	width := len("File")
+	for _, f := range summary.files {
+		if len(f.name) > width {
+			width = len(f.name)
+		}
+	}
+
+	var bytes int64
+	failed := 0
+	fmt.Printf("%-*s  %10s  %8s  %12s  %s\n", width, "File", "Size", "Time", "Rate", "Result")
+	for _, f := range summary.files {
+		elapsed := f.finished.Sub(f.started)
+		result := "ok"
+		if f.err != nil {
+			result = "failed"
+			failed++
+		} else {
+			bytes += f.size
+		}
+		fmt.Printf("%-*s  %10s  %8s  %12s  %s\n", width, f.name, formatBytes(f.size), formatDuration(elapsed), formatBytes(rate(f.size, elapsed))+"/s", result)
+	}
+	fmt.Printf("%d files, %d failed, %s in %s (%s/s)\n", len(summary.files), failed, formatBytes(bytes), formatDuration(summary.elapsed), formatBytes(rate(bytes, summary.elapsed)))
+}
+
+// formatBytes formats n with a binary unit, such as "12.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
+
+// formatDuration formats d to the millisecond below a second, to a tenth of
+// a second below a minute and to the second above.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
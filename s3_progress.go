@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// transferProgress follows a batch of uploads, downloads or moves. On a
+// terminal it keeps a status line up to date with the bytes transferred,
+// throughput and ETA of the whole batch and the progress of a file in
+// flight. It prints a line as each file completes and keeps every file's
+// outcome for the summary table.
+//
+// A nil *transferProgress, like a nil *fileProgress, tracks nothing, so
+// operations take one whether or not progress is shown.
+type transferProgress struct {
+	verb string
+	out  io.Writer
+	live bool
+
+	mu          sync.Mutex
+	started     time.Time
+	expectFiles int
+	expectBytes int64
+	files       []*fileProgress
+	drawn       time.Time
+}
+
+// fileProgress is the progress of one file in a batch.
+type fileProgress struct {
+	batch    *transferProgress
+	name     string
+	size     int64
+	done     int64
+	started  time.Time
+	finished time.Time
+	err      error
+}
+
+// progressInterval is how often the status line is redrawn at most.
+const progressInterval = 100 * time.Millisecond
+
+// newTransferProgress returns a tracker that reports to standard output,
+// describing completed files with verb, such as "Uploaded". The status line
+// is only drawn when standard output is a terminal.
+func newTransferProgress(verb string) *transferProgress {
+	return &transferProgress{verb: verb, out: os.Stdout, live: isTerminal(os.Stdout), started: time.Now(), expectBytes: -1}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// expect sets the number of files in the batch and their total size, or -1
+// if the size is not known in advance. Without a total size, the ETA is
+// estimated from the average size of the files started so far.
+func (p *transferProgress) expect(files int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expectFiles, p.expectBytes = files, bytes
+}
+
+// start begins tracking a file of size bytes.
+func (p *transferProgress) start(name string, size int64) *fileProgress {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := &fileProgress{batch: p, name: name, size: size, started: time.Now()}
+	p.files = append(p.files, f)
+	p.draw(false)
+	return f
+}
+
+// add records n more bytes of the file as transferred. n is negative when a
+// request is retried and its bytes are sent again.
+func (f *fileProgress) add(n int64) {
+	if f == nil || n == 0 {
+		return
+	}
+	p := f.batch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.done += n
+	p.draw(false)
+}
+
+// finish records the outcome of the file and, if it succeeded, prints it.
+func (f *fileProgress) finish(err error) {
+	if f == nil {
+		return
+	}
+	p := f.batch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.finished, f.err = time.Now(), err
+	if err != nil {
+		p.draw(true)
+		return
+	}
+	f.done = f.size
+
+	p.clear()
+	elapsed := f.finished.Sub(f.started)
+	fmt.Fprintf(p.out, "%s %s (%s in %s, %s/s)\n", p.verb, f.name, formatBytes(f.size), formatDuration(elapsed), formatBytes(rate(f.size, elapsed)))
+	p.draw(true)
+}
+
+// reader counts the bytes read from r as transferred.
+func (f *fileProgress) reader(r io.Reader) io.Reader {
+	if f == nil {
+		return r
+	}
+	return &countingReader{Reader: r, count: f.add}
+}
+
+// uploadOption returns an s3manager option that counts the file contents of
+// the upload as they are sent.
+func (f *fileProgress) uploadOption() func(*s3manager.Uploader) {
+	return func(u *s3manager.Uploader) {
+		if f != nil {
+			u.RequestOptions = append(u.RequestOptions, f.countRequestBody)
+		}
+	}
+}
+
+func (f *fileProgress) countRequestBody(r *request.Request) {
+	// Only these requests carry file contents; the others send XML.
+	if name := r.Operation.Name; name != "PutObject" && name != "UploadPart" {
+		return
+	}
+	var sent int64
+	r.Handlers.Send.PushFront(func(r *request.Request) {
+		// A retry sends the whole body again.
+		f.add(-atomic.SwapInt64(&sent, 0))
+		if r.HTTPRequest.Body == nil || r.HTTPRequest.Body == http.NoBody {
+			return
+		}
+		r.HTTPRequest.Body = &countingReadCloser{
+			ReadCloser: r.HTTPRequest.Body,
+			count: func(n int64) {
+				atomic.AddInt64(&sent, n)
+				f.add(n)
+			},
+		}
+	})
+}
+
+type countingReader struct {
+	io.Reader
+	count func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.count(int64(n))
+	return n, err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	count func(n int64)
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count(int64(n))
+	return n, err
+}
+
+// stop removes the status line once the batch is over.
+func (p *transferProgress) stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// draw redraws the status line, at most every progressInterval unless force
+// is set. The caller holds p.mu.
+func (p *transferProgress) draw(force bool) {
+	if !p.live || (!force && time.Since(p.drawn) < progressInterval) {
+		return
+	}
+	p.drawn = time.Now()
+
+	var done, known int64
+	finished := 0
+	var current *fileProgress
+	for _, f := range p.files {
+		done += f.done
+		known += f.size
+		if !f.finished.IsZero() {
+			finished++
+		} else if current == nil {
+			current = f
+		}
+	}
+	total := p.expectBytes
+	if total < 0 {
+		total = known
+		if started := len(p.files); started > 0 && p.expectFiles > started {
+			total += known / int64(started) * int64(p.expectFiles-started)
+		}
+	}
+	files := p.expectFiles
+	if files < len(p.files) {
+		files = len(p.files)
+	}
+
+	elapsed := time.Since(p.started)
+	speed := rate(done, elapsed)
+	eta := "--"
+	if speed > 0 && total >= done {
+		eta = formatDuration(time.Duration(float64(total-done) / float64(speed) * float64(time.Second)))
+	}
+
+	line := fmt.Sprintf("%s %s / %s  %s/s  ETA %s  %d/%d files", progressBar(done, total), formatBytes(done), formatBytes(total), formatBytes(speed), eta, finished, files)
+	if current != nil {
+		line += fmt.Sprintf("  %s %d%%", current.name, percent(current.done, current.size))
+	}
+	fmt.Fprint(p.out, "\r"+line+"\033[K")
+}
+
+// clear erases the status line. The caller holds p.mu.
+func (p *transferProgress) clear() {
+	if p.live && !p.drawn.IsZero() {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+}
+
+// transferSummary is the outcome of a batch, for the summary table.
+type transferSummary struct {
+	files   []fileProgress
+	elapsed time.Duration
+}
+
+func (p *transferProgress) summary() transferSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := transferSummary{elapsed: time.Since(p.started)}
+	for _, f := range p.files {
+		s.files = append(s.files, *f)
+	}
+	return s
+}
+
+func progressBar(done, total int64) string {
+	const width = 20
+	filled := percent(done, total) * width / 100
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), percent(done, total))
+}
+
+func percent(done, total int64) int {
+	if total <= 0 {
+		return 100
+	}
+	if done >= total {
+		return 100
+	}
+	if done < 0 {
+		return 0
+	}
+	return int(done * 100 / total)
+}
+
+// rate returns the bytes per second of n bytes sent in elapsed.
+func rate(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// protectedList holds buckets and prefixes that must never be deleted from,
+// written as "bucket" or "bucket/prefix". The bucket may be a path.Match
+// pattern such as "prod-*".
+type protectedList []string
+
+// covers reports whether deleting key in bucket is forbidden.
+func (p protectedList) covers(bucket, key string) bool {
+	for _, entry := range p {
+		entryBucket, entryPrefix, _ := strings.Cut(entry, "/")
+		if ok, _ := path.Match(entryBucket, bucket); ok && strings.HasPrefix(key, entryPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error if deleting everything under prefix in bucket would
+// touch a protected bucket or prefix. An empty prefix stands for the whole
+// bucket.
+func (p protectedList) check(bucket, prefix string) error {
+	for _, entry := range p {
+		entryBucket, entryPrefix, _ := strings.Cut(entry, "/")
+		if ok, _ := path.Match(entryBucket, bucket); !ok {
+			continue
+		}
+		if strings.HasPrefix(prefix, entryPrefix) || strings.HasPrefix(entryPrefix, prefix) {
+			return fmt.Errorf("s3://%s/%s is protected by %q", bucket, prefix, entry)
+		}
+	}
+	return nil
+}
+
+// protectedClient is an s3iface.S3API that refuses to delete protected
+// buckets and objects and passes everything else through.
+type protectedClient struct {
+	s3iface.S3API
+	protected protectedList
+}
+
+func errProtected(bucket, key string) error {
+	return fmt.Errorf("s3://%s/%s is protected", bucket, key)
+}
+
+func (p *protectedClient) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	if err := p.protected.check(aws.StringValue(input.Bucket), ""); err != nil {
+		return nil, err
+	}
+	return p.S3API.DeleteBucket(input)
+}
+
+func (p *protectedClient) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	if p.protected.covers(bucket, key) {
+		return nil, errProtected(bucket, key)
+	}
+	return p.S3API.DeleteObject(input)
+}
+
+func (p *protectedClient) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	if p.protected.covers(bucket, key) {
+		return nil, errProtected(bucket, key)
+	}
+	return p.S3API.AbortMultipartUpload(input)
+}
+
+// PutBucketLifecycleConfiguration refuses rules that would expire objects
+// under a protected prefix, since S3 would delete them later on its own.
+func (p *protectedClient) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	for _, rule := range input.LifecycleConfiguration.Rules {
+		if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil {
+			continue
+		}
+		if err := p.protected.check(bucket, lifecyclePrefix(rule)); err != nil {
+			return nil, fmt.Errorf("lifecycle rule %q expires objects: %w", aws.StringValue(rule.ID), err)
+		}
+	}
+	return p.S3API.PutBucketLifecycleConfiguration(input)
+}
+
+// DeleteObjects deletes the unprotected objects and reports the protected
+// ones as failed.
+func (p *protectedClient) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	var allowed []*s3.ObjectIdentifier
+	var refused []*s3.Error
+	for _, object := range input.Delete.Objects {
+		if p.protected.covers(bucket, aws.StringValue(object.Key)) {
+			refused = append(refused, &s3.Error{
+				Key:     object.Key,
+				Code:    aws.String("Protected"),
+				Message: aws.String(errProtected(bucket, aws.StringValue(object.Key)).Error()),
+			})
+			continue
+		}
+		allowed = append(allowed, object)
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	if len(allowed) > 0 {
+		forwarded := *input
+		forwarded.Delete = &s3.Delete{Objects: allowed, Quiet: input.Delete.Quiet}
+		var err error
+		if output, err = p.S3API.DeleteObjects(&forwarded); err != nil {
+			return nil, err
+		}
+	}
+	output.Errors = append(output.Errors, refused...)
+	return output, nil
+}
+
+// checkProtected returns an error if svc refuses deletions under prefix in
+// bucket.
+func checkProtected(svc s3iface.S3API, bucket, prefix string) error {
+	if p, ok := svc.(*protectedClient); ok {
+		return p.protected.check(bucket, prefix)
+	}
+	return nil
+}
+
+// checkProtectedKey returns an error if svc refuses to delete key in bucket.
+func checkProtectedKey(svc s3iface.S3API, bucket, key string) error {
+	if p, ok := svc.(*protectedClient); ok && p.protected.covers(bucket, key) {
+		return errProtected(bucket, key)
+	}
+	return nil
+}
+
+// dryRunOf returns the dry-run client behind svc, if there is one.
+func dryRunOf(svc s3iface.S3API) (*dryRunClient, bool) {
+	if p, ok := svc.(*protectedClient); ok {
+		svc = p.S3API
+	}
+	d, ok := svc.(*dryRunClient)
+	return d, ok
+}
+
+// errCancelled is returned when the user does not confirm a destructive
+// action.
+var errCancelled = errors.New("cancelled")
+
+// deletionScope counts the objects under prefix and their total size.
+func deletionScope(svc s3iface.S3API, bucket, prefix string) (count int, size int64, err error) {
+	err = forEachObjectPage(svc, bucket, prefix, func(page []*s3.Object) error {
+		for _, item := range page {
+			count++
+			size += aws.Int64Value(item.Size)
+		}
+		return nil
+	})
+	return count, size, err
+}
+
+// keysScope counts the given keys that exist and their total size.
+func keysScope(svc s3iface.S3API, bucket string, keys []string) (count int, size int64) {
+	for _, key := range keys {
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			continue
+		}
+		count++
+		size += aws.Int64Value(head.ContentLength)
+	}
+	return count, size
+}
+
+// confirm prints the description of what a destructive action is about to
+// destroy and asks the user to type answer before it goes ahead, returning
+// errCancelled if they do not. There is no prompt with -yes or during a dry
+// run, and describe is not called.
+func (a *app) confirm(answer string, describe func() (string, error)) error {
+	if a.opts.yes || a.opts.dryRun {
+		return nil
+	}
+	description, err := describe()
+	if err != nil {
+		fmt.Fprintln(errorOutput, "Error:", err)
+		return err
+	}
+	fmt.Println(description)
+	fmt.Printf("Type %q to confirm: ", answer)
+	typed, err := a.reader.ReadString('\n')
+	if strings.TrimSpace(typed) == answer {
+		return nil
+	}
+	if err != nil {
+		fmt.Println("\nNo confirmation received; use -yes to confirm in scripts.")
+	} else {
+		fmt.Println("Cancelled.")
+	}
+	return errCancelled
+}
+
+// confirmDeleteKeys refuses to delete protected keys and otherwise asks the
+// user to confirm deleting the keys that exist.
+func (a *app) confirmDeleteKeys(bucket string, keys []string) error {
+	for _, key := range keys {
+		if a.opts.protected.covers(bucket, key) {
+			err := errProtected(bucket, key)
+			fmt.Fprintln(errorOutput, "Refusing to delete:", err)
+			return err
+		}
+	}
+	return a.confirm("delete", func() (string, error) {
+		count, size := keysScope(a.client(), bucket, keys)
+		return fmt.Sprintf("This will permanently delete %d objects (%d bytes) from s3://%s.", count, size, bucket), nil
+	})
+}
+
+// confirmDeleteFolder refuses to delete a folder that overlaps a protected
+// prefix and otherwise asks the user to confirm by typing the folder name.
+func (a *app) confirmDeleteFolder(bucket, folder string) error {
+	prefix := folder + "/"
+	if err := a.opts.protected.check(bucket, prefix); err != nil {
+		fmt.Fprintln(errorOutput, "Refusing to delete:", err)
+		return err
+	}
+	return a.confirm(folder, func() (string, error) {
+		count, size, err := deletionScope(a.client(), bucket, prefix)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("This will permanently delete %d objects (%d bytes) under s3://%s/%s.", count, size, bucket, prefix), nil
+	})
+}
+
+// confirmRestore refuses a point-in-time restore that would delete from a
+// protected prefix and otherwise asks the user to confirm the plan, which
+// has already been printed, by typing "restore".
+func (a *app) confirmRestore(plan *restorePlan) error {
+	for _, action := range plan.actions {
+		if action.kind == "delete" && a.opts.protected.covers(plan.bucket, action.key) {
+			err := errProtected(plan.bucket, action.key)
+			fmt.Fprintln(errorOutput, "Refusing to restore:", err)
+			return err
+		}
+	}
+	return a.confirm("restore", func() (string, error) {
+		return fmt.Sprintf("This will change %d objects under s3://%s/%s.", len(plan.actions), plan.bucket, plan.target), nil
+	})
+}
+
+// confirmDeleteBucket refuses to delete a protected bucket and otherwise asks
+// the user to confirm by typing the bucket name. When the bucket is to be
+// emptied first, the description counts every version and upload in it.
+func (a *app) confirmDeleteBucket(bucket string, empty bool) error {
+	if err := a.opts.protected.check(bucket, ""); err != nil {
+		fmt.Fprintln(errorOutput, "Refusing to delete:", err)
+		return err
+	}
+	return a.confirm(bucket, func() (string, error) {
+		if empty {
+			versions, size, uploads, err := bucketContents(a.client(), bucket)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("This will permanently delete the bucket s3://%s and everything in it: %d object versions and delete markers (%d bytes) and %d multipart uploads in progress.", bucket, versions, size, uploads), nil
+		}
+		count, size, err := deletionScope(a.client(), bucket, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("This will permanently delete the bucket s3://%s, which holds %d objects (%d bytes).", bucket, count, size), nil
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestProtectedList(t *testing.T) {
+	p := protectedList{"prod-*", "b/keep/"}
+	for _, tt := range []struct {
+		bucket, key string
+		covered     bool
+	}{
+		{"prod-eu", "anything", true},
+		{"b", "keep/x", true},
+		{"b", "keeper", false},
+		{"b", "other", false},
+		{"staging", "keep/x", false},
+	} {
+		if got := p.covers(tt.bucket, tt.key); got != tt.covered {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.bucket, tt.key, got, tt.covered)
+		}
+	}
+	for _, tt := range []struct {
+		bucket, prefix string
+		refused        bool
+	}{
+		{"b", "", true},
+		{"b", "keep/sub/", true},
+		{"b", "other/", false},
+		{"prod-eu", "x/", true},
+	} {
+		if err := p.check(tt.bucket, tt.prefix); (err != nil) != tt.refused {
+			t.Errorf("check(%q, %q) = %v, want refused %v", tt.bucket, tt.prefix, err, tt.refused)
+		}
+	}
+}
+
+func TestProtectedClient(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	for _, key := range []string{"keep/a", "keep/b", "tmp/c", "tmp/d"} {
+		putTestObject(t, svc, "b", key, "x")
+	}
+	protected := &protectedClient{S3API: svc, protected: protectedList{"b/keep/"}}
+
+	if err := deleteSingleFile(protected, "b", "keep/a"); err == nil {
+		t.Error("deleted a protected object")
+	}
+	res, err := deleteMultipleFiles(protected, "b", []string{"keep/b", "tmp/c"})
+	if err == nil || len(res.succeeded) != 1 || len(res.failed) != 1 {
+		t.Errorf("want tmp/c deleted and keep/b refused, got %v", err)
+	}
+	if _, err := deleteFolder(protected, "b", "tmp"); err != nil {
+		t.Errorf("deleting an unprotected folder: %v", err)
+	}
+	if _, err := deleteFolder(protected, "b", "keep"); err == nil {
+		t.Error("deleted a protected folder")
+	}
+	if err := deleteBucket(protected, "b"); err == nil {
+		t.Error("deleted a bucket with a protected prefix")
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"keep/a", "keep/b"}) {
+		t.Errorf("keys left = %v", keys)
+	}
+
+	// A refused move must not leave a copy behind.
+	if err := renameFile(protected, "b", "keep/a", "moved/a"); err == nil {
+		t.Error("moved a protected object")
+	}
+	if _, err := moveFolders(protected, "b", []string{"keep"}, []string{"moved"}, nil); err == nil {
+		t.Error("moved a protected folder")
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"keep/a", "keep/b"}) {
+		t.Errorf("keys after refused moves = %v", keys)
+	}
+
+	expire := func(prefix string) error {
+		rule, err := newLifecycleRule(lifecycleRuleSpec{id: "r", prefix: prefix, expireDays: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return putLifecycle(protected, "b", []*s3.LifecycleRule{rule})
+	}
+	if err := expire("keep/"); err == nil {
+		t.Error("put a rule expiring protected objects")
+	}
+	if err := expire(""); err == nil {
+		t.Error("put a rule expiring the whole bucket")
+	}
+	if err := expire("tmp/"); err != nil {
+		t.Errorf("putting a rule expiring unprotected objects: %v", err)
+	}
+	transition := &s3.LifecycleRule{
+		ID:          aws.String("archive"),
+		Status:      aws.String(s3.ExpirationStatusEnabled),
+		Filter:      lifecycleFilter("keep/", nil),
+		Transitions: []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String(s3.TransitionStorageClassGlacier)}},
+	}
+	if err := putLifecycle(protected, "b", []*s3.LifecycleRule{transition}); err != nil {
+		t.Errorf("putting a rule that only moves protected objects: %v", err)
+	}
+}
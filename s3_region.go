@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// clientFactory builds S3 clients for any region from one session, so that
+// every client signs with the same credentials (including assumed-role and
+// typed-in keys) and keeps the endpoint, addressing style and CA bundle the
+// session was built with. Clients are built once per region, and the region
+// of each bucket is looked up once.
+type clientFactory struct {
+	sess     *session.Session
+	endpoint string
+
+	// routed is false when every bucket is reached through one fixed
+	// endpoint, such as a custom endpoint or the in-memory backend, so that
+	// bucket regions are not looked up.
+	routed bool
+
+	mu      sync.Mutex
+	clients map[string]*s3.S3
+	buckets map[string]string
+}
+
+func newClientFactory(sess *session.Session, opts sessionOptions) *clientFactory {
+	endpoint := endpointTemplate(opts)
+	return &clientFactory{
+		sess:     sess,
+		endpoint: endpoint,
+		routed:   aws.StringValue(sess.Config.Endpoint) == "" || strings.Contains(endpoint, "{region}"),
+		clients:  make(map[string]*s3.S3),
+		buckets:  make(map[string]string),
+	}
+}
+
+// client returns the client for region. A provider endpoint that names the
+// region is rebuilt for it; any other endpoint is kept as it is.
+func (f *clientFactory) client(region string) *s3.S3 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if svc, ok := f.clients[region]; ok {
+		return svc
+	}
+	cfg := &aws.Config{Region: aws.String(region)}
+	if strings.Contains(f.endpoint, "{region}") {
+		cfg.Endpoint = aws.String(regionEndpoint(f.endpoint, region))
+	}
+	svc := s3.New(f.sess, cfg)
+	f.clients[region] = svc
+	return svc
+}
+
+// bucketRegion returns the region bucket lives in, asking svc with
+// GetBucketLocation or, when that is not allowed or not answered from this
+// region, with HeadBucket. Only successful lookups are remembered, so a
+// bucket that does not exist yet is looked up again later. It returns "" when
+// the region cannot be found or does not matter.
+func (f *clientFactory) bucketRegion(svc *s3.S3, bucket string) string {
+	if !f.routed || bucket == "" {
+		return ""
+	}
+	f.mu.Lock()
+	region, ok := f.buckets[bucket]
+	f.mu.Unlock()
+	if ok {
+		return region
+	}
+
+	location, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		region = s3.NormalizeBucketLocation(aws.StringValue(location.LocationConstraint))
+	} else if region, err = s3manager.GetBucketRegionWithClient(context.Background(), svc, bucket); err != nil {
+		return ""
+	}
+
+	f.mu.Lock()
+	f.buckets[bucket] = region
+	f.mu.Unlock()
+	return region
+}
+
+func (f *clientFactory) remember(bucket, region string) {
+	f.mu.Lock()
+	f.buckets[bucket] = region
+	f.mu.Unlock()
+}
+
+func (f *clientFactory) forget(bucket string) {
+	f.mu.Lock()
+	delete(f.buckets, bucket)
+	f.mu.Unlock()
+}
+
+// regionRouter is an s3iface.S3API that sends each bucket operation to a
+// client for the bucket's region, so that buckets outside the selected region
+// work without PermanentRedirect errors. Calls that do not name a bucket go
+// to the client for the selected region.
+//
+// The embedded S3API is left nil, so that an API without an override here
+// panics instead of quietly going to the selected region.
+type regionRouter struct {
+	s3iface.S3API
+	selected *s3.S3
+	clients  *clientFactory
+}
+
+func newRegionRouter(clients *clientFactory, region string) *regionRouter {
+	return &regionRouter{selected: clients.client(region), clients: clients}
+}
+
+// region returns the selected region.
+func (r *regionRouter) region() string {
+	return aws.StringValue(r.selected.Config.Region)
+}
+
+// bucketRegion returns the region of bucket, or the selected region when it
+// cannot be found.
+func (r *regionRouter) bucketRegion(bucket string) string {
+	if region := r.clients.bucketRegion(r.selected, bucket); region != "" {
+		return region
+	}
+	return r.region()
+}
+
+// bucketClient returns the client for the region of bucket.
+func (r *regionRouter) bucketClient(bucket *string) *s3.S3 {
+	region := r.clients.bucketRegion(r.selected, aws.StringValue(bucket))
+	if region == "" || region == r.region() {
+		return r.selected
+	}
+	return r.clients.client(region)
+}
+
+// ListBuckets names no bucket, so it goes to the selected region.
+func (r *regionRouter) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	return r.selected.ListBuckets(input)
+}
+
+// CreateBucket creates bucket with the client for its LocationConstraint and
+// remembers its region.
+func (r *regionRouter) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	region := "us-east-1"
+	if input.CreateBucketConfiguration != nil {
+		region = s3.NormalizeBucketLocation(aws.StringValue(input.CreateBucketConfiguration.LocationConstraint))
+	}
+	svc := r.selected
+	if r.clients.routed {
+		svc = r.clients.client(region)
+	}
+	output, err := svc.CreateBucket(input)
+	if err == nil && r.clients.routed {
+		r.clients.remember(aws.StringValue(input.Bucket), region)
+	}
+	return output, err
+}
+
+// DeleteBucket deletes bucket in its region and forgets the region, as the
+// name may be reused elsewhere.
+func (r *regionRouter) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	output, err := r.bucketClient(input.Bucket).DeleteBucket(input)
+	if err == nil {
+		r.clients.forget(aws.StringValue(input.Bucket))
+	}
+	return output, err
+}
+
+func (r *regionRouter) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	return r.bucketClient(input.Bucket).AbortMultipartUpload(input)
+}
+
+func (r *regionRouter) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return r.bucketClient(input.Bucket).CopyObject(input)
+}
+
+func (r *regionRouter) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return r.bucketClient(input.Bucket).CreateMultipartUpload(input)
+}
+
+func (r *regionRouter) DeleteBucketEncryption(input *s3.DeleteBucketEncryptionInput) (*s3.DeleteBucketEncryptionOutput, error) {
+	return r.bucketClient(input.Bucket).DeleteBucketEncryption(input)
+}
+
+func (r *regionRouter) DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error) {
+	return r.bucketClient(input.Bucket).DeleteBucketLifecycle(input)
+}
+
+func (r *regionRouter) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	return r.bucketClient(input.Bucket).DeleteBucketPolicy(input)
+}
+
+func (r *regionRouter) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return r.bucketClient(input.Bucket).DeleteObject(input)
+}
+
+func (r *regionRouter) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	return r.bucketClient(input.Bucket).DeleteObjects(input)
+}
+
+func (r *regionRouter) GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	return r.bucketClient(input.Bucket).GetBucketEncryption(input)
+}
+
+func (r *regionRouter) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	return r.bucketClient(input.Bucket).GetBucketLifecycleConfiguration(input)
+}
+
+func (r *regionRouter) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	return r.bucketClient(input.Bucket).GetBucketLocation(input)
+}
+
+func (r *regionRouter) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	return r.bucketClient(input.Bucket).GetBucketVersioning(input)
+}
+
+func (r *regionRouter) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return r.bucketClient(input.Bucket).GetObject(input)
+}
+
+func (r *regionRouter) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return r.bucketClient(input.Bucket).HeadObject(input)
+}
+
+func (r *regionRouter) PutBucketAcl(input *s3.PutBucketAclInput) (*s3.PutBucketAclOutput, error) {
+	return r.bucketClient(input.Bucket).PutBucketAcl(input)
+}
+
+func (r *regionRouter) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+	return r.bucketClient(input.Bucket).PutBucketEncryption(input)
+}
+
+func (r *regionRouter) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	return r.bucketClient(input.Bucket).PutBucketLifecycleConfiguration(input)
+}
+
+func (r *regionRouter) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	return r.bucketClient(input.Bucket).PutBucketPolicy(input)
+}
+
+func (r *regionRouter) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	return r.bucketClient(input.Bucket).PutBucketVersioning(input)
+}
+
+func (r *regionRouter) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return r.bucketClient(input.Bucket).PutObject(input)
+}
+
+func (r *regionRouter) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	return r.bucketClient(input.Bucket).PutPublicAccessBlock(input)
+}
+
+func (r *regionRouter) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	return r.bucketClient(input.Bucket).AbortMultipartUploadWithContext(ctx, input, opts...)
+}
+
+func (r *regionRouter) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	return r.bucketClient(input.Bucket).CompleteMultipartUploadWithContext(ctx, input, opts...)
+}
+
+func (r *regionRouter) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	return r.bucketClient(input.Bucket).CreateMultipartUploadWithContext(ctx, input, opts...)
+}
+
+func (r *regionRouter) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	return r.bucketClient(input.Bucket).UploadPartWithContext(ctx, input, opts...)
+}
+
+func (r *regionRouter) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	return r.bucketClient(input.Bucket).GetObjectRequest(input)
+}
+
+func (r *regionRouter) PutObjectRequest(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	return r.bucketClient(input.Bucket).PutObjectRequest(input)
+}
+
+func (r *regionRouter) ListMultipartUploadsPages(input *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	return r.bucketClient(input.Bucket).ListMultipartUploadsPages(input, fn)
+}
+
+func (r *regionRouter) ListObjectVersionsPages(input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	return r.bucketClient(input.Bucket).ListObjectVersionsPages(input, fn)
+}
+
+func (r *regionRouter) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return r.bucketClient(input.Bucket).ListObjectsV2Pages(input, fn)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+// TestRegionRouterCoversOperations fails when an operation calls an API that
+// regionRouter does not override, since such a call panics.
+func TestRegionRouterCoversOperations(t *testing.T) {
+	src := parseS3Source(t)
+	routed := src.methods("regionRouter")
+	for _, name := range src.operationCalls() {
+		if !routed[name] {
+			t.Errorf("regionRouter does not override %s", name)
+		}
+	}
+}
@@ -4,91 +4,90 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-func getBucketInfo(svc *s3.S3, bucket string) {
+var validACLs = []string{"private", "public-read", "public-read-write", "authenticated-read", "aws-exec-read", "bucket-owner-read", "bucket-owner-full-control", "log-delivery-write"}
+
+// bucketInfo is the information shown for a bucket.
+type bucketInfo struct {
+	name     string
+	location string
+}
+
+// objectInfo is the information shown for an object.
+type objectInfo struct {
+	key          string
+	size         int64
+	lastModified time.Time
+	contentType  string
+	encryption   string
+}
+
+func getBucketInfo(svc s3iface.S3API, bucket string) (*bucketInfo, error) {
 	input := &s3.GetBucketLocationInput{
 		Bucket: aws.String(bucket),
 	}
 
 	result, err := svc.GetBucketLocation(input)
 	if err != nil {
-		fmt.Println("Error getting bucket information:", err)
-		return
+		return nil, err
 	}
 
-	fmt.Printf("Bucket: %s\n", bucket)
-	fmt.Printf("Location: %s\n", aws.StringValue(result.LocationConstraint))
+	return &bucketInfo{
+		name:     bucket,
+		location: aws.StringValue(result.LocationConstraint),
+	}, nil
 }
 
-func getObjectInfo(svc *s3.S3, bucket, objectKey string) {
-	input := &s3.GetObjectInput{
+func getObjectInfo(svc s3iface.S3API, bucket, objectKey string) (*objectInfo, error) {
+	input := &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(objectKey),
 	}
 
-	result, err := svc.GetObject(input)
+	result, err := svc.HeadObject(input)
 	if err != nil {
-		fmt.Println("Error getting object information:", err)
-		return
+		return nil, err
 	}
 
-	fmt.Printf("Object Key: %s\n", objectKey)
-	fmt.Printf("Size: %d bytes\n", aws.Int64Value(result.ContentLength))
-	fmt.Printf("Last Modified: %s\n", aws.TimeValue(result.LastModified))
-	fmt.Printf("Content Type: %s\n", aws.StringValue(result.ContentType))
+	return &objectInfo{
+		key:          objectKey,
+		size:         aws.Int64Value(result.ContentLength),
+		lastModified: aws.TimeValue(result.LastModified),
+		contentType:  aws.StringValue(result.ContentType),
+		encryption:   objectEncryption(result),
+	}, nil
 }
 
-func setBucketPolicy(svc *s3.S3, bucket, policy string) {
+func setBucketPolicy(svc s3iface.S3API, bucket, policy string) error {
 	input := &s3.PutBucketPolicyInput{
 		Bucket: aws.String(bucket),
 		Policy: aws.String(policy),
 	}
 
 	_, err := svc.PutBucketPolicy(input)
-	if err != nil {
-		fmt.Println("Error setting bucket policy:", err)
-		return
-	}
-
-	fmt.Println("Bucket policy set successfully.")
+	return err
 }
 
-func deleteBucketPolicy(svc *s3.S3, bucket string) {
+func deleteBucketPolicy(svc s3iface.S3API, bucket string) error {
 	input := &s3.DeleteBucketPolicyInput{
 		Bucket: aws.String(bucket),
 	}
 
 	_, err := svc.DeleteBucketPolicy(input)
-	if err != nil {
-		fmt.Println("Error deleting bucket policy:", err)
-		return
-	}
-
-	fmt.Println("Bucket policy deleted successfully.")
+	return err
 }
 
-func setBucketACL(svc *s3.S3, bucket, acl string) {
-	validACLs := []string{"private", "public-read", "public-read-write", "authenticated-read", "aws-exec-read", "bucket-owner-read", "bucket-owner-full-control", "log-delivery-write"}
-	isValidACL := false
-	for _, validACL := range validACLs {
-		if acl == validACL {
-			isValidACL = true
-			break
-		}
-	}
-
-	if !isValidACL {
-		fmt.Println("Invalid ACL value. Please use one of the following:", strings.Join(validACLs, ", "))
-		return
+func setBucketACL(svc s3iface.S3API, bucket, acl string) error {
+	if !contains(validACLs, acl) {
+		return fmt.Errorf("invalid ACL value %q, please use one of the following: %s", acl, strings.Join(validACLs, ", "))
 	}
 
 	input := &s3.PutBucketAclInput{
@@ -97,213 +96,141 @@ func setBucketACL(svc *s3.S3, bucket, acl string) {
 	}
 
 	_, err := svc.PutBucketAcl(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			fmt.Println("Error Code:", aerr.Code())
-			fmt.Println("Message:", aerr.Message())
-			fmt.Println("Orig Err:", aerr.OrigErr())
-		} else {
-			fmt.Println(err.Error())
-		}
-		fmt.Println("Error setting bucket ACL:", err)
-		return
-	}
-
-	fmt.Println("Bucket ACL set successfully.")
+	return err
 }
 
-func deleteBucket(svc s3iface.S3API, region string, bucket string) error {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
-	if err != nil {
-		fmt.Println("Error creating session:", err)
+func deleteBucket(svc s3iface.S3API, bucket string) error {
+	if err := checkProtected(svc, bucket, ""); err != nil {
 		return err
 	}
 
-	newS3Client := s3.New(sess)
-
 	input := &s3.DeleteBucketInput{
 		Bucket: aws.String(bucket),
 	}
 
-	_, err = newS3Client.DeleteBucket(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			fmt.Println("Error Code:", aerr.Code())
-			fmt.Println("Message:", aerr.Message())
-			fmt.Println("Orig Err:", aerr.OrigErr())
-		} else {
-			fmt.Println(err.Error())
-		}
-		fmt.Println("Error deleting bucket:", err)
-		return err
-	}
-
-	fmt.Println("Bucket deleted successfully.")
-	return nil
-}
-
-func setRegion(svc *s3.S3, region string) {
-	svc.Config.Region = aws.String(region)
-	fmt.Println("Region set successfully to:", region)
+	_, err := svc.DeleteBucket(input)
+	return err
 }
 
-func moveFiles(svc *s3.S3, bucket, sourceFolder, destinationFolder string, fileKeys []string) {
+func moveFiles(svc s3iface.S3API, bucket, sourceFolder, destinationFolder string, fileKeys []string) (*batchResult, error) {
+	res := &batchResult{}
 	for _, fileKey := range fileKeys {
 		sourceKey := sourceFolder + "/" + fileKey
 		destinationKey := destinationFolder + "/" + fileKey
 
-		_, err := svc.CopyObject(&s3.CopyObjectInput{
-			Bucket:     aws.String(bucket),
-			CopySource: aws.String(bucket + "/" + sourceKey),
-			Key:        aws.String(destinationKey),
-		})
-		if err != nil {
-			fmt.Println("Error copying file:", err)
-			continue
-		}
-
-		_, err = svc.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(sourceKey),
-		})
-		if err != nil {
-			fmt.Println("Error deleting original file:", err)
-			continue
-		}
-
-		fmt.Printf("File %s moved successfully from %s to %s.\n", fileKey, sourceFolder, destinationFolder)
+		res.add(fileKey, moveObject(svc, bucket, sourceKey, destinationKey))
 	}
+	return res, res.err()
 }
 
-func renameFile(svc *s3.S3, bucket, originalKey, newKey string) {
+// moveObject copies an object to a new key in the same bucket and then
+// deletes the original. A protected original is refused before the copy, which
+// would otherwise be left behind as a duplicate.
+func moveObject(svc s3iface.S3API, bucket, sourceKey, destinationKey string) error {
+	if err := checkProtectedKey(svc, bucket, sourceKey); err != nil {
+		return err
+	}
 	_, err := svc.CopyObject(&s3.CopyObjectInput{
 		Bucket:     aws.String(bucket),
-		CopySource: aws.String(bucket + "/" + originalKey),
-		Key:        aws.String(newKey),
+		CopySource: aws.String(copySource(bucket, sourceKey, "")),
+		Key:        aws.String(destinationKey),
 	})
 	if err != nil {
-		fmt.Println("Error copying file:", err)
-		return
+		return fmt.Errorf("copying object: %w", err)
 	}
 
 	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(originalKey),
+		Key:    aws.String(sourceKey),
 	})
 	if err != nil {
-		fmt.Println("Error deleting original file:", err)
-		return
+		return fmt.Errorf("deleting original object: %w", err)
 	}
+	return nil
+}
 
-	fmt.Printf("File %s renamed successfully to %s.\n", originalKey, newKey)
+func renameFile(svc s3iface.S3API, bucket, originalKey, newKey string) error {
+	return moveObject(svc, bucket, originalKey, newKey)
 }
 
-func moveFolders(svc *s3.S3, bucket string, sourceFolders, destinationFolders []string) {
+// moveFolders lists every folder first and then moves the objects one by
+// one, so that the size of the whole move is known for progress.
+func moveFolders(svc s3iface.S3API, bucket string, sourceFolders, destinationFolders []string, progress *transferProgress) (*batchResult, error) {
+	type move struct {
+		sourceKey, destinationKey string
+		size                      int64
+	}
+
+	res := &batchResult{}
+	var moves []move
+	var total int64
 	for i, sourceFolder := range sourceFolders {
 		destinationFolder := destinationFolders[i]
 
-		resp, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(sourceFolder + "/"),
-		})
-		if err != nil {
-			fmt.Println("Error listing objects:", err)
+		if strings.HasPrefix(destinationFolder+"/", sourceFolder+"/") {
+			// Objects moved into the source prefix would be listed again.
+			res.add(sourceFolder+"/", fmt.Errorf("cannot move a folder into itself (%s)", destinationFolder))
 			continue
 		}
-
-		for _, item := range resp.Contents {
-			sourceKey := aws.StringValue(item.Key)
-			destinationKey := strings.Replace(sourceKey, sourceFolder, destinationFolder, 1)
-
-			_, err := svc.CopyObject(&s3.CopyObjectInput{
-				Bucket:     aws.String(bucket),
-				CopySource: aws.String(bucket + "/" + sourceKey),
-				Key:        aws.String(destinationKey),
-			})
-			if err != nil {
-				fmt.Println("Error copying object:", err)
-				continue
-			}
-
-			_, err = svc.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(sourceKey),
-			})
-			if err != nil {
-				fmt.Println("Error deleting original object:", err)
-				continue
-			}
-		}
-
-		fmt.Printf("Folder %s moved successfully to %s.\n", sourceFolder, destinationFolder)
-	}
-}
-
-func renameFolders(svc *s3.S3, bucket string, originalFolders, newFolders []string) {
-	for i, originalFolder := range originalFolders {
-		newFolder := newFolders[i]
-
-		resp, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(originalFolder + "/"),
-		})
-		if err != nil {
-			fmt.Println("Error listing objects:", err)
+		if err := checkProtected(svc, bucket, sourceFolder+"/"); err != nil {
+			res.add(sourceFolder+"/", err)
 			continue
 		}
 
-		for _, item := range resp.Contents {
-			originalKey := aws.StringValue(item.Key)
-			newKey := strings.Replace(originalKey, originalFolder, newFolder, 1)
-
-			_, err := svc.CopyObject(&s3.CopyObjectInput{
-				Bucket:     aws.String(bucket),
-				CopySource: aws.String(bucket + "/" + originalKey),
-				Key:        aws.String(newKey),
-			})
-			if err != nil {
-				fmt.Println("Error copying object:", err)
-				continue
-			}
-
-			_, err = svc.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(originalKey),
-			})
-			if err != nil {
-				fmt.Println("Error deleting original object:", err)
-				continue
+		err := forEachObjectPage(svc, bucket, sourceFolder+"/", func(page []*s3.Object) error {
+			for _, item := range page {
+				sourceKey := aws.StringValue(item.Key)
+				destinationKey := strings.Replace(sourceKey, sourceFolder, destinationFolder, 1)
+				moves = append(moves, move{sourceKey, destinationKey, aws.Int64Value(item.Size)})
+				total += aws.Int64Value(item.Size)
 			}
+			return nil
+		})
+		if err != nil {
+			res.add(sourceFolder+"/", err)
 		}
+	}
 
-		fmt.Printf("Folder %s renamed successfully to %s.\n", originalFolder, newFolder)
+	progress.expect(len(moves), total)
+	for _, m := range moves {
+		fp := progress.start(m.sourceKey, m.size)
+		err := moveObject(svc, bucket, m.sourceKey, m.destinationKey)
+		fp.finish(err)
+		res.add(m.sourceKey, err)
 	}
+	return res, res.err()
+}
+
+func renameFolders(svc s3iface.S3API, bucket string, originalFolders, newFolders []string, progress *transferProgress) (*batchResult, error) {
+	return moveFolders(svc, bucket, originalFolders, newFolders, progress)
 }
 
-func generatePreSignedURL(svc *s3.S3, bucket, objectName string, duration int64) {
+func generatePreSignedURL(svc s3iface.S3API, bucket, objectName string, duration int64) (string, error) {
 	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(objectName),
 	})
-	urlStr, err := req.Presign(time.Duration(duration) * time.Minute)
-	if err != nil {
-		fmt.Println("Error generating pre-signed URL:", err)
-		return
-	}
+	return req.Presign(time.Duration(duration) * time.Minute)
+}
 
+// presignURL generates a pre-signed URL for objectName and, if shorten is
+// set, replaces it with a tinyurl.com link.
+func presignURL(svc s3iface.S3API, bucket, objectName string, duration int64, shorten bool) (string, error) {
+	urlStr, err := generatePreSignedURL(svc, bucket, objectName, duration)
+	if err != nil || !shorten {
+		return urlStr, err
+	}
 	shortURL, err := shortenURL(urlStr)
 	if err != nil {
-		fmt.Println("Error shortening URL:", err)
-		return
+		return "", fmt.Errorf("shortening URL: %w", err)
 	}
-
-	fmt.Printf("Pre-signed URL for object %s: %s\n", objectName, shortURL)
+	return shortURL, nil
 }
 
+// shortenURL asks tinyurl.com for a short link to urlStr. A pre-signed URL
+// grants access to its object, so it is only sent over HTTPS.
 func shortenURL(urlStr string) (string, error) {
-	resp, err := http.Get("http://tinyurl.com/api-create.php?url=" + urlStr)
+	resp, err := http.Get("https://tinyurl.com/api-create.php?url=" + url.QueryEscape(urlStr))
 	if err != nil {
 		return "", err
 	}
@@ -313,6 +240,9 @@ func shortenURL(urlStr string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("tinyurl.com answered %s", resp.Status)
+	}
 
-	return string(body), nil
+	return strings.TrimSpace(string(body)), nil
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// sessionOptions holds the global flags that control how the AWS session is
+// built. Empty values defer to the standard AWS environment variables and
+// shared configuration files.
+type sessionOptions struct {
+	profile string
+	region  string
+
+	roleARN         string
+	externalID      string
+	roleSessionName string
+	mfaSerial       string
+	roleDuration    time.Duration
+
+	provider   string
+	accountID  string
+	endpoint   string
+	pathStyle  bool
+	disableSSL bool
+	caBundle   string
+
+	fake bool
+}
+
+// providerPreset describes how to reach an S3-compatible store. The endpoint
+// may contain {region} and {account} placeholders, which are filled in from
+// the session region and the -account-id flag.
+type providerPreset struct {
+	endpoint  string
+	region    string
+	pathStyle bool
+}
+
+var providerPresets = map[string]providerPreset{
+	"aws":        {},
+	"minio":      {endpoint: "http://localhost:9000", region: "us-east-1", pathStyle: true},
+	"ceph":       {endpoint: "http://localhost:7480", region: "us-east-1", pathStyle: true},
+	"localstack": {endpoint: "http://localhost:4566", region: "us-east-1", pathStyle: true},
+	"r2":         {endpoint: "https://{account}.r2.cloudflarestorage.com", region: "auto"},
+	"wasabi":     {endpoint: "https://s3.{region}.wasabisys.com", region: "us-east-1"},
+	"b2":         {endpoint: "https://s3.{region}.backblazeb2.com", region: "us-west-004"},
+	"spaces":     {endpoint: "https://{region}.digitaloceanspaces.com", region: "nyc3"},
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providerPresets))
+	for name := range providerPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o *sessionOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.profile, "profile", "", "named profile from ~/.aws/config and ~/.aws/credentials (defaults to $AWS_PROFILE)")
+	fs.StringVar(&o.region, "region", "", "AWS region (defaults to $AWS_REGION or the profile's region)")
+	fs.StringVar(&o.roleARN, "role-arn", "", "ARN of a role to assume with the base credentials")
+	fs.StringVar(&o.externalID, "external-id", "", "external ID required by the role's trust policy")
+	fs.StringVar(&o.roleSessionName, "role-session-name", "", "session name recorded for the assumed role (default s3interact-<timestamp>)")
+	fs.StringVar(&o.mfaSerial, "mfa-serial", "", "serial number or ARN of the MFA device; the token code is prompted for")
+	fs.DurationVar(&o.roleDuration, "role-duration", time.Hour, "lifetime of each set of assumed-role credentials")
+	fs.StringVar(&o.provider, "provider", "aws", "S3-compatible provider preset: "+strings.Join(providerNames(), ", "))
+	fs.StringVar(&o.accountID, "account-id", "", "account ID used in the provider's endpoint (Cloudflare R2)")
+	fs.StringVar(&o.endpoint, "endpoint", "", "custom S3 endpoint URL, overriding the provider preset")
+	fs.BoolVar(&o.pathStyle, "path-style", false, "address buckets as https://endpoint/bucket instead of https://bucket.endpoint")
+	fs.BoolVar(&o.disableSSL, "disable-ssl", false, "use plain HTTP for endpoints given without a scheme")
+	fs.StringVar(&o.caBundle, "ca-bundle", "", "PEM file of additional CA certificates to trust (defaults to $AWS_CA_BUNDLE)")
+	fs.BoolVar(&o.fake, "fake", false, "serve an in-memory S3 backend locally and use it instead of AWS (for demos and offline testing)")
+}
+
+// newSession builds a session from the standard AWS credential chain:
+// environment variables, the shared credentials and config files (including
+// session tokens, credential_process and SSO profiles) and container or
+// instance roles. When prompt is set and nothing is configured, the user is
+// asked for a region and static keys instead. MFA codes are read from reader,
+// which must be the one the rest of the program reads standard input with,
+// since a second reader would take input meant for the first.
+//
+// If a role ARN is given, the base credentials are used to assume it and the
+// resulting temporary credentials are refreshed shortly before they expire,
+// prompting for a fresh MFA code each time when an MFA device is configured.
+//
+// A provider preset or explicit endpoint points the session at an
+// S3-compatible store instead of AWS.
+func newSession(opts sessionOptions, reader *bufio.Reader, prompt bool) (*session.Session, error) {
+	if opts.fake {
+		return newFakeSession(opts)
+	}
+
+	preset, ok := providerPresets[opts.provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q; use one of %s", opts.provider, strings.Join(providerNames(), ", "))
+	}
+
+	sessOpts := session.Options{
+		Profile:                 opts.profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: mfaTokenProvider(reader, "the profile's MFA device"),
+		AssumeRoleDuration:      opts.roleDuration,
+	}
+	if opts.region != "" {
+		sessOpts.Config.Region = aws.String(opts.region)
+	}
+	if opts.pathStyle || preset.pathStyle {
+		sessOpts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if opts.disableSSL {
+		sessOpts.Config.DisableSSL = aws.Bool(true)
+	}
+	if opts.caBundle != "" {
+		bundle, err := os.Open(opts.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("opening CA bundle: %w", err)
+		}
+		defer bundle.Close()
+		sessOpts.CustomCABundle = bundle
+	}
+
+	sess, err := session.NewSessionWithOptions(sessOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(sess.Config.Region) == "" && preset.region != "" {
+		sess.Config.Region = aws.String(preset.region)
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		if !prompt {
+			return nil, errors.New("no region configured; use -region, AWS_REGION or a profile with a region")
+		}
+		fmt.Print("Enter AWS Region (e.g., eu-west-2): ")
+		region, _ := reader.ReadString('\n')
+		sess.Config.Region = aws.String(strings.TrimSpace(region))
+	}
+
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		var aerr awserr.Error
+		if !prompt || !errors.As(err, &aerr) || aerr.Code() != "NoCredentialProviders" {
+			return nil, fmt.Errorf("loading credentials: %w", err)
+		}
+
+		fmt.Println("No AWS credentials found in the environment or shared configuration.")
+		fmt.Print("Enter AWS Key ID: ")
+		awsKeyID, _ := reader.ReadString('\n')
+
+		fmt.Print("Enter AWS Secret Key: ")
+		awsSecretKey, _ := reader.ReadString('\n')
+
+		sess = sess.Copy(&aws.Config{
+			Credentials: credentials.NewStaticCredentials(strings.TrimSpace(awsKeyID), strings.TrimSpace(awsSecretKey), ""),
+		})
+	}
+
+	if opts.roleARN != "" {
+		sessionName := opts.roleSessionName
+		if sessionName == "" {
+			sessionName = fmt.Sprintf("s3interact-%d", time.Now().Unix())
+		}
+
+		creds := stscreds.NewCredentials(sess, opts.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			p.Duration = opts.roleDuration
+			p.ExpiryWindow = time.Minute
+			if opts.externalID != "" {
+				p.ExternalID = aws.String(opts.externalID)
+			}
+			if opts.mfaSerial != "" {
+				p.SerialNumber = aws.String(opts.mfaSerial)
+				p.TokenProvider = mfaTokenProvider(reader, opts.mfaSerial)
+			}
+		})
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("assuming role %s: %w", opts.roleARN, err)
+		}
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	endpoint := opts.endpoint
+	if endpoint == "" && preset.endpoint != "" {
+		if strings.Contains(preset.endpoint, "{account}") && opts.accountID == "" {
+			return nil, fmt.Errorf("the %s provider needs -account-id", opts.provider)
+		}
+		endpoint = regionEndpoint(endpointTemplate(opts), aws.StringValue(sess.Config.Region))
+	}
+	if endpoint != "" {
+		// Applied last so that STS calls made while assuming a role still go
+		// to AWS rather than to the storage endpoint.
+		sess.Config.Endpoint = aws.String(endpoint)
+	}
+
+	return sess, nil
+}
+
+// endpointTemplate returns the provider preset's endpoint with the account
+// filled in but {region} left in place, or "" when the endpoint does not
+// depend on the region.
+func endpointTemplate(opts sessionOptions) string {
+	if opts.fake || opts.endpoint != "" {
+		return ""
+	}
+	preset := providerPresets[opts.provider]
+	return strings.ReplaceAll(preset.endpoint, "{account}", opts.accountID)
+}
+
+func regionEndpoint(template, region string) string {
+	return strings.ReplaceAll(template, "{region}", region)
+}
+
+// mfaTokenProvider returns a token provider that prompts for an MFA code. It
+// is called again whenever the assumed-role credentials need refreshing, so
+// long interactive sessions keep working after the first set expires.
+func mfaTokenProvider(reader *bufio.Reader, device string) func() (string, error) {
+	return func() (string, error) {
+		fmt.Fprintf(os.Stderr, "Enter MFA code for %s: ", device)
+		code, err := reader.ReadString('\n')
+		code = strings.TrimSpace(code)
+		if code == "" {
+			if err == nil {
+				err = errors.New("no MFA code entered")
+			}
+			return "", err
+		}
+		return code, nil
+	}
+}
+
+// newFakeSession starts an in-memory S3 backend on a local port and returns a
+// session that talks to it. The backend lives until the process exits. The
+// session is built without reading the environment, since AWS_CA_BUNDLE
+// would replace the server's certificate in the client's trusted roots.
+func newFakeSession(opts sessionOptions) (*session.Session, error) {
+	region := opts.region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	srv := newFakeS3().server()
+	fmt.Fprintln(os.Stderr, "Using an in-memory S3 backend at", srv.URL)
+
+	config := defaults.Config()
+	config.MergeIn(&aws.Config{
+		Region:           aws.String(region),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("fake", "fake", ""),
+		HTTPClient:       srv.Client(),
+	})
+	return &session.Session{Config: config, Handlers: defaults.Handlers()}, nil
+}
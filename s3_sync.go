@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// syncLocation is one side of a sync: a prefix in a bucket when bucket is
+// set, otherwise a local folder.
+type syncLocation struct {
+	bucket string
+	prefix string
+	dir    string
+}
+
+// parseSyncLocation reads an s3://bucket/prefix URI or a local folder path.
+func parseSyncLocation(s string) syncLocation {
+	if bucket, prefix, ok := parseS3URI(s); ok {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return syncLocation{bucket: bucket, prefix: prefix}
+	}
+	return syncLocation{dir: s}
+}
+
+func (l syncLocation) isS3() bool {
+	return l.bucket != ""
+}
+
+func (l syncLocation) String() string {
+	if l.isS3() {
+		return "s3://" + l.bucket + "/" + l.prefix
+	}
+	return l.dir
+}
+
+// syncOptions controls how a sync compares and what it changes.
+//
+// By default files of the same size are compared by modification time, taken
+// from the mtime metadata s3interact records on upload or else the object's
+// LastModified date. With checksum set they are compared by content instead,
+// using the MD5 of local files and the ETag of objects.
+type syncOptions struct {
+	delete   bool
+	checksum bool
+	dryRun   bool
+}
+
+// syncFile is a file on one side of a sync, named by its slash-separated
+// path relative to the location.
+type syncFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+	// hasModTime is false for objects without mtime metadata, whose modTime
+	// is only the date they were last written to S3.
+	hasModTime bool
+	etag       string
+	path       string
+	key        string
+}
+
+// syncAction is one change a sync makes to its target.
+type syncAction struct {
+	kind   string // "upload", "download", "copy" or "delete"
+	name   string
+	size   int64
+	reason string
+	file   *syncFile
+}
+
+// syncPlan lists the changes that bring target in line with source.
+type syncPlan struct {
+	source    syncLocation
+	target    syncLocation
+	actions   []syncAction
+	unchanged int
+}
+
+func planSync(svc s3iface.S3API, source, target syncLocation, opts syncOptions, transfer transferOptions) (*syncPlan, error) {
+	if !source.isS3() && !target.isS3() {
+		return nil, errors.New("at least one side of a sync must be an s3:// location")
+	}
+
+	// Between a folder and a bucket, client-side encrypted objects are
+	// compared by the size of their contents, which takes the objects to be
+	// encrypted, and cannot be compared by checksum.
+	encrypted := transfer.cse.master != nil && source.isS3() != target.isS3()
+	if encrypted && opts.checksum {
+		return nil, errors.New("-checksum cannot compare files with client-side encrypted objects")
+	}
+
+	sourceFiles, err := listSyncFiles(svc, source, false)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", source, err)
+	}
+	targetFiles, err := listSyncFiles(svc, target, true)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", target, err)
+	}
+	if encrypted {
+		objects := sourceFiles
+		if target.isS3() {
+			objects = targetFiles
+		}
+		for _, f := range objects {
+			f.size = plaintextSize(f.size)
+		}
+	}
+
+	if !opts.checksum {
+		if err := resolveModTimes(svc, source, target, sourceFiles, targetFiles, transfer); err != nil {
+			return nil, err
+		}
+	}
+
+	kind := "copy"
+	switch {
+	case !source.isS3():
+		kind = "upload"
+	case !target.isS3():
+		kind = "download"
+	}
+
+	plan := &syncPlan{source: source, target: target}
+	for _, name := range sortedNames(sourceFiles) {
+		src := sourceFiles[name]
+		reason, err := changeReason(src, targetFiles[name], opts, transfer)
+		if err != nil {
+			return nil, fmt.Errorf("comparing %s: %w", name, err)
+		}
+		if reason == "" {
+			plan.unchanged++
+			continue
+		}
+		plan.actions = append(plan.actions, syncAction{kind: kind, name: name, size: src.size, reason: reason, file: src})
+	}
+
+	if opts.delete {
+		for _, name := range sortedNames(targetFiles) {
+			if _, ok := sourceFiles[name]; !ok {
+				dst := targetFiles[name]
+				plan.actions = append(plan.actions, syncAction{kind: "delete", name: name, size: dst.size, reason: "not in source", file: dst})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// listSyncFiles returns the files at loc by name. A missing local folder is
+// treated as empty when missingOK is set.
+func listSyncFiles(svc s3iface.S3API, loc syncLocation, missingOK bool) (map[string]*syncFile, error) {
+	files := make(map[string]*syncFile)
+
+	if loc.isS3() {
+		err := forEachObjectPage(svc, loc.bucket, loc.prefix, func(page []*s3.Object) error {
+			for _, item := range page {
+				key := aws.StringValue(item.Key)
+				if strings.HasSuffix(key, "/") {
+					continue
+				}
+				name := strings.TrimPrefix(key, loc.prefix)
+				files[name] = &syncFile{
+					name:    name,
+					size:    aws.Int64Value(item.Size),
+					modTime: aws.TimeValue(item.LastModified),
+					etag:    aws.StringValue(item.ETag),
+					key:     key,
+				}
+			}
+			return nil
+		})
+		return files, err
+	}
+
+	if _, err := os.Stat(loc.dir); missingOK && errors.Is(err, os.ErrNotExist) {
+		return files, nil
+	}
+	var errs []error
+	err := walkLocalFiles(loc.dir, directoryUploadOptions{}, func(filePath, relPath string) {
+		// Leave the partial files of interrupted downloads alone.
+		if strings.HasSuffix(relPath, partialSuffix) || strings.HasSuffix(relPath, partialSuffix+stateSuffix) {
+			return
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		files[relPath] = &syncFile{
+			name:       relPath,
+			size:       info.Size(),
+			modTime:    info.ModTime(),
+			hasModTime: true,
+			path:       filePath,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, errors.Join(errs...)
+}
+
+// resolveModTimes reads the mtime metadata of the objects whose modification
+// time decides whether they changed: those that exist on both sides with the
+// same size and, between two buckets, a different ETag.
+func resolveModTimes(svc s3iface.S3API, source, target syncLocation, sourceFiles, targetFiles map[string]*syncFile, transfer transferOptions) error {
+	type lookup struct {
+		bucket string
+		file   *syncFile
+	}
+	var lookups []lookup
+	for name, src := range sourceFiles {
+		dst, ok := targetFiles[name]
+		if !ok || dst.size != src.size || (src.etag != "" && src.etag == dst.etag) {
+			continue
+		}
+		if source.isS3() {
+			lookups = append(lookups, lookup{source.bucket, src})
+		}
+		if target.isS3() {
+			lookups = append(lookups, lookup{target.bucket, dst})
+		}
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	parallel(len(lookups), transfer.fileConcurrency, func(i int) {
+		l := lookups[i]
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(l.bucket),
+			Key:    aws.String(l.file.key),
+		})
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("reading %s: %w", l.file.key, err))
+			mu.Unlock()
+			return
+		}
+		l.file.modTime, l.file.hasModTime = objectModTime(head.Metadata, head.LastModified)
+	})
+	return errors.Join(errs...)
+}
+
+// changeReason says why src must be copied over dst, or returns "" if dst is
+// up to date.
+func changeReason(src, dst *syncFile, opts syncOptions, transfer transferOptions) (string, error) {
+	switch {
+	case dst == nil:
+		return "new", nil
+	case src.size != dst.size:
+		return "size differs", nil
+	case src.etag != "" && src.etag == dst.etag:
+		return "", nil
+	case opts.checksum:
+		same, err := sameContent(src, dst, transfer.partSize())
+		if err != nil || same {
+			return "", err
+		}
+		return "content differs", nil
+	case !dst.hasModTime:
+		// An object written by another tool: only replace it with newer data.
+		if src.modTime.After(dst.modTime) {
+			return "newer", nil
+		}
+		return "", nil
+	case src.modTime.Unix() != dst.modTime.Unix():
+		return "modified", nil
+	}
+	return "", nil
+}
+
+// sameContent compares a file with an object, or two objects, by checksum.
+func sameContent(a, b *syncFile, partSize int64) (bool, error) {
+	if a.etag != "" && b.etag != "" {
+		return a.etag == b.etag, nil
+	}
+	local, object := a, b
+	if local.etag != "" {
+		local, object = b, a
+	}
+	etag, err := fileETag(local.path, object.etag, partSize)
+	if err != nil {
+		return false, err
+	}
+	return etag == object.etag, nil
+}
+
+// fileETag computes the ETag S3 would give the file at filePath: the MD5 of
+// its content, or for an object uploaded in parts (like want, whose ETag
+// ends in the number of parts) the MD5 of the part MD5s with the part count.
+func fileETag(filePath, want string, partSize int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if !strings.Contains(want, "-") {
+		hash := md5.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+		return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+	}
+
+	var sums []byte
+	parts := 0
+	for {
+		hash := md5.New()
+		n, err := io.CopyN(hash, file, partSize)
+		if n > 0 {
+			sums = hash.Sum(sums)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	total := md5.Sum(sums)
+	return `"` + hex.EncodeToString(total[:]) + "-" + strconv.Itoa(parts) + `"`, nil
+}
+
+// applySync carries out the plan, copying every new or changed file across
+// and deleting extraneous files from the target when the plan includes
+// them, and records the outcome under each name.
+func applySync(svc s3iface.S3API, plan *syncPlan, transfer transferOptions, progress *transferProgress) *batchResult {
+	source, target := plan.source, plan.target
+	res := &batchResult{}
+
+	var uploads []fileUpload
+	var downloads []fileDownload
+	var copies, deletes []*syncFile
+	for _, action := range plan.actions {
+		switch action.kind {
+		case "upload":
+			uploads = append(uploads, fileUpload{path: action.file.path, key: target.prefix + action.name})
+		case "download":
+			relPath := filepath.FromSlash(action.name)
+			if !filepath.IsLocal(relPath) {
+				res.add(action.file.key, errors.New("key does not map to a path inside the destination folder"))
+				continue
+			}
+			downloads = append(downloads, fileDownload{key: action.file.key, path: filepath.Join(target.dir, relPath)})
+		case "copy":
+			copies = append(copies, action.file)
+		case "delete":
+			deletes = append(deletes, action.file)
+		}
+	}
+
+	if len(uploads) > 0 {
+		res.merge(uploadFiles(svc, target.bucket, uploads, transfer, progress))
+	}
+	if len(downloads) > 0 {
+		res.merge(downloadFiles(svc, source.bucket, downloads, transfer, progress))
+	}
+	parallel(len(copies), transfer.fileConcurrency, func(i int) {
+		key := target.prefix + copies[i].name
+		res.add(key, copySyncObject(svc, source.bucket, copies[i].key, target.bucket, key))
+	})
+
+	if target.isS3() {
+		objects := make([]*s3.ObjectIdentifier, len(deletes))
+		for i, file := range deletes {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(file.key)}
+		}
+		if err := deleteObjects(svc, target.bucket, objects, res); err != nil {
+			for _, file := range deletes {
+				res.add(file.key, err)
+			}
+		}
+	} else {
+		for _, file := range deletes {
+			res.add(file.path, os.Remove(file.path))
+		}
+	}
+	return res
+}
+
+// copySyncObject copies an object between buckets and records the source's
+// modification time in the copy's metadata, so that later syncs see the two
+// as the same.
+func copySyncObject(svc s3iface.S3API, sourceBucket, sourceKey, targetBucket, targetKey string) error {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(sourceBucket),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]*string, len(head.Metadata)+1)
+	for name, value := range head.Metadata {
+		if !strings.EqualFold(name, mtimeMetadata) {
+			metadata[name] = value
+		}
+	}
+	modTime, _ := objectModTime(head.Metadata, head.LastModified)
+	metadata[mtimeMetadata] = aws.String(strconv.FormatInt(modTime.Unix(), 10))
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:             aws.String(targetBucket),
+		Key:                aws.String(targetKey),
+		CopySource:         aws.String(copySource(sourceBucket, sourceKey, "")),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           metadata,
+		ContentType:        head.ContentType,
+		ContentEncoding:    head.ContentEncoding,
+		ContentDisposition: head.ContentDisposition,
+		ContentLanguage:    head.ContentLanguage,
+		CacheControl:       head.CacheControl,
+	})
+	return err
+}
+
+func sortedNames(files map[string]*syncFile) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
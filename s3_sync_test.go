@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// syncTestFiles plans and applies a sync and returns the changes it made,
+// as "kind name".
+func syncTestFiles(t *testing.T, svc s3iface.S3API, source, target string, opts syncOptions) []string {
+	t.Helper()
+	plan, err := planSync(svc, parseSyncLocation(source), parseSyncLocation(target), opts, testTransfer)
+	if err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	if err := applySync(svc, plan, testTransfer, nil).err(); err != nil {
+		t.Fatalf("applying: %v", err)
+	}
+	var changes []string
+	for _, action := range plan.actions {
+		changes = append(changes, action.kind+" "+action.name)
+	}
+	return changes
+}
+
+func touch(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncUpload(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"})
+	putTestObject(t, svc, "b", "backup/extra", "x")
+
+	if got := syncTestFiles(t, svc, dir, "s3://b/backup", syncOptions{}); !equalStrings(got, []string{"upload a.txt", "upload sub/b.txt"}) {
+		t.Errorf("first sync = %q", got)
+	}
+	if got := syncTestFiles(t, svc, dir, "s3://b/backup", syncOptions{}); len(got) != 0 {
+		t.Errorf("second sync = %q, want no changes", got)
+	}
+
+	// Same size, later modification time.
+	writeTestFiles(t, dir, map[string]string{"a.txt": "ALPHA"})
+	touch(t, filepath.Join(dir, "a.txt"), time.Now().Add(time.Hour))
+	if got := syncTestFiles(t, svc, dir, "s3://b/backup", syncOptions{delete: true}); !equalStrings(got, []string{"upload a.txt", "delete extra"}) {
+		t.Errorf("sync after a change = %q", got)
+	}
+	if got, _ := readTestObject(t, svc, "b", "backup/a.txt"); got != "ALPHA" {
+		t.Errorf("backup/a.txt = %q", got)
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"backup/a.txt", "backup/sub/b.txt"}) {
+		t.Errorf("keys = %v", keys)
+	}
+}
+
+func TestSyncDownload(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	src := t.TempDir()
+	writeTestFiles(t, src, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"})
+	syncTestFiles(t, svc, src, "s3://b/data", syncOptions{})
+
+	dst := filepath.Join(t.TempDir(), "restore")
+	if got := syncTestFiles(t, svc, "s3://b/data", dst, syncOptions{}); len(got) != 2 {
+		t.Errorf("first sync = %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(dst, "sub", "b.txt")); got != "bravo" {
+		t.Errorf("sub/b.txt = %q", got)
+	}
+	if got := syncTestFiles(t, svc, "s3://b/data", dst, syncOptions{}); len(got) != 0 {
+		t.Errorf("second sync = %q, want no changes", got)
+	}
+
+	writeTestFiles(t, dst, map[string]string{"local-only": "x"})
+	if got := syncTestFiles(t, svc, "s3://b/data", dst, syncOptions{delete: true}); !equalStrings(got, []string{"delete local-only"}) {
+		t.Errorf("sync with -delete = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "local-only")); !os.IsNotExist(err) {
+		t.Errorf("local-only was not deleted: %v", err)
+	}
+}
+
+func TestSyncBetweenBuckets(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if err := createBucket(svc, "c", bucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, svc, "b", "from/a", "alpha")
+	if got := syncTestFiles(t, svc, "s3://b/from", "s3://c/to", syncOptions{}); !equalStrings(got, []string{"copy a"}) {
+		t.Errorf("first sync = %q", got)
+	}
+	if got := syncTestFiles(t, svc, "s3://b/from", "s3://c/to", syncOptions{}); len(got) != 0 {
+		t.Errorf("second sync = %q, want no changes", got)
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha", "big": string(randomBytes(testPartSize + 1))})
+	syncTestFiles(t, svc, dir, "s3://b/", syncOptions{})
+
+	// Rewriting a file with other content of the same size and the old
+	// modification time goes unnoticed without -checksum.
+	info, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, map[string]string{"a.txt": "ALPHA"})
+	touch(t, filepath.Join(dir, "a.txt"), info.ModTime())
+	touch(t, filepath.Join(dir, "big"), time.Now().Add(time.Hour))
+
+	if got := syncTestFiles(t, svc, dir, "s3://b/", syncOptions{}); !equalStrings(got, []string{"upload big"}) {
+		t.Errorf("sync by modification time = %q", got)
+	}
+	if got := syncTestFiles(t, svc, dir, "s3://b/", syncOptions{checksum: true}); !equalStrings(got, []string{"upload a.txt"}) {
+		t.Errorf("sync by checksum = %q", got)
+	}
+}
+
+func TestSyncNeedsABucket(t *testing.T) {
+	_, svc, _ := newTestFake(t, "")
+	if _, err := planSync(svc, parseSyncLocation(t.TempDir()), parseSyncLocation(t.TempDir()), syncOptions{}, testTransfer); err == nil {
+		t.Error("planned a sync between two folders")
+	}
+}
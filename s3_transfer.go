@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// transferOptions tunes how file contents are moved to and from S3. The zero
+// value uses the transfer engine's defaults and moves one file at a time.
+type transferOptions struct {
+	partSizeMiB     int64
+	concurrency     int
+	fileConcurrency int
+	cse             cseOptions
+}
+
+func (o *transferOptions) addFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&o.partSizeMiB, "part-size", 16, "size in MiB of each part of a multipart transfer (at least 5)")
+	fs.IntVar(&o.concurrency, "concurrency", 5, "number of parts of a single file transferred in parallel")
+	fs.IntVar(&o.fileConcurrency, "file-concurrency", 4, "number of files transferred in parallel")
+	o.cse.addFlags(fs)
+}
+
+// partSize returns the part size in bytes.
+func (o *transferOptions) partSize() int64 {
+	if o.partSizeMiB > 0 {
+		return o.partSizeMiB * 1024 * 1024
+	}
+	return s3manager.DefaultUploadPartSize
+}
+
+func (o *transferOptions) validate() error {
+	if o.partSizeMiB != 0 && o.partSizeMiB*1024*1024 < s3manager.MinUploadPartSize {
+		return fmt.Errorf("-part-size must be at least %d MiB", s3manager.MinUploadPartSize/1024/1024)
+	}
+	if o.concurrency < 0 || o.fileConcurrency < 0 {
+		return fmt.Errorf("-concurrency and -file-concurrency must not be negative")
+	}
+	return o.cse.validate()
+}
+
+// fileUploader sends files to S3. It is satisfied by *s3manager.Uploader and
+// by *dryRunClient, which only records the uploads.
+type fileUploader interface {
+	Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+// newUploader returns an upload engine that sends files smaller than one part
+// with a single PutObject and larger ones as multipart uploads, several parts
+// at a time. A multipart upload that fails is aborted so that its parts do
+// not linger in the bucket.
+func newUploader(svc s3iface.S3API, opts transferOptions) fileUploader {
+	if d, ok := dryRunOf(svc); ok {
+		return d
+	}
+	return s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = opts.partSize()
+		if opts.concurrency > 0 {
+			u.Concurrency = opts.concurrency
+		}
+		u.LeavePartsOnError = false
+	})
+}
+
+// mtimeMetadata is the user metadata entry that holds the modification time
+// of an uploaded file, in seconds since the Unix epoch.
+const mtimeMetadata = "mtime"
+
+// objectModTime returns the modification time recorded in an object's
+// metadata, or its LastModified date and false if there is none.
+func objectModTime(metadata map[string]*string, lastModified *time.Time) (time.Time, bool) {
+	for name, value := range metadata {
+		if !strings.EqualFold(name, mtimeMetadata) {
+			continue
+		}
+		if secs, err := strconv.ParseInt(aws.StringValue(value), 10, 64); err == nil {
+			return time.Unix(secs, 0), true
+		}
+	}
+	return aws.TimeValue(lastModified), false
+}
+
+// uploadFile sends the file at filePath to key with uploader, recording the
+// file's modification time in the object's metadata. With a master key the
+// file is encrypted as it is read; the upload engine buffers each part of
+// the encrypted stream, so large files still go up as multipart uploads.
+func uploadFile(uploader fileUploader, bucket, filePath, key string, master *masterKey, progress *transferProgress) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Body:     file,
+		Metadata: map[string]*string{mtimeMetadata: aws.String(strconv.FormatInt(info.ModTime().Unix(), 10))},
+	}
+	size := info.Size()
+	// A dry run sends nothing, so there is nothing to encrypt.
+	if _, dryRun := uploader.(*dryRunClient); master != nil && !dryRun {
+		metadata, dataKey, err := master.seal()
+		if err != nil {
+			return fmt.Errorf("encrypting file: %w", err)
+		}
+		if input.Body, err = newEncryptReader(file, dataKey); err != nil {
+			return fmt.Errorf("encrypting file: %w", err)
+		}
+		for name, value := range metadata {
+			input.Metadata[name] = value
+		}
+		size = encryptedSize(size)
+	}
+
+	fp := progress.start(key, size)
+	_, err = uploader.Upload(input, fp.uploadOption())
+	fp.finish(err)
+	return err
+}
+
+// fileUpload is a local file and the key it is uploaded to.
+type fileUpload struct {
+	path string
+	key  string
+}
+
+// uploadFiles uploads every file, running up to opts.fileConcurrency uploads
+// at once, and records the outcome under each file's key.
+func uploadFiles(svc s3iface.S3API, bucket string, files []fileUpload, opts transferOptions, progress *transferProgress) *batchResult {
+	if progress != nil {
+		var total int64
+		for _, f := range files {
+			if info, err := os.Stat(f.path); err == nil {
+				total += info.Size()
+			}
+		}
+		progress.expect(len(files), total)
+	}
+
+	uploader := newUploader(svc, opts)
+	res := &batchResult{}
+	parallel(len(files), opts.fileConcurrency, func(i int) {
+		res.add(files[i].key, uploadFile(uploader, bucket, files[i].path, files[i].key, opts.cse.master, progress))
+	})
+	return res
+}
+
+// partialSuffix is appended to a download's destination path to name the
+// temporary file it is written to. The download's progress is recorded next
+// to it in a file with stateSuffix appended as well.
+const (
+	partialSuffix = ".s3partial"
+	stateSuffix   = ".state"
+)
+
+// downloadState records which parts of an object have been written to a
+// partial download, so an interrupted download can carry on where it
+// stopped. It is only valid for the object version it was started for.
+type downloadState struct {
+	ETag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"partSize"`
+	Done     []bool `json:"done"`
+}
+
+// matches reports whether the state belongs to a download of the same
+// object version split into the same parts.
+func (s *downloadState) matches(etag string, size, partSize int64) bool {
+	return s.ETag == etag && s.Size == size && s.PartSize == partSize && int64(len(s.Done)) == partCount(size, partSize)
+}
+
+func partCount(size, partSize int64) int64 {
+	return (size + partSize - 1) / partSize
+}
+
+// downloadFile fetches key, or the given version of it, into destinationPath
+// in byte ranges of the configured part size, several ranges at a time,
+// creating any missing parent directories. The ranges are written into a
+// temporary file next to the destination, which is renamed into place once
+// every range has arrived and given the object's modification time. If a
+// previous attempt at the same object version was interrupted, the ranges it
+// completed are not fetched again. A client-side encrypted object is
+// downloaded as it is stored and decrypted into place at the end.
+func downloadFile(svc s3iface.S3API, bucket, key, versionID, destinationPath string, opts transferOptions, progress *transferProgress) (err error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	head, err := svc.HeadObject(input)
+	if err != nil {
+		return err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	etag := aws.StringValue(head.ETag)
+	partSize := opts.partSize()
+	dataKey, err := opts.cse.master.open(head.Metadata)
+	if err != nil {
+		return err
+	}
+
+	if d, ok := dryRunOf(svc); ok {
+		detail := "to " + destinationPath
+		if versionID != "" {
+			detail = versionDetail(&versionID) + " " + detail
+		}
+		d.record("download", objectURI(bucket, key), detail, size)
+		return nil
+	}
+
+	fp := progress.start(key, size)
+	defer func() { fp.finish(err) }()
+
+	if err := os.MkdirAll(filepath.Dir(destinationPath), 0o755); err != nil {
+		return fmt.Errorf("creating folder: %w", err)
+	}
+
+	partialPath := destinationPath + partialSuffix
+	statePath := partialPath + stateSuffix
+	state := loadDownloadState(statePath)
+	if state == nil || !state.matches(etag, size, partSize) {
+		state = &downloadState{ETag: etag, Size: size, PartSize: partSize, Done: make([]bool, partCount(size, partSize))}
+		os.Remove(partialPath)
+	}
+
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("writing to file: %w", err)
+	}
+
+	var pending []int
+	for part, done := range state.Done {
+		if !done {
+			pending = append(pending, part)
+		} else if end := int64(part+1) * partSize; end > size {
+			fp.add(size - int64(part)*partSize)
+		} else {
+			fp.add(partSize)
+		}
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	parallel(len(pending), opts.concurrency, func(i int) {
+		part := pending[i]
+		err := downloadRange(svc, bucket, key, versionID, etag, file, int64(part)*partSize, partSize, size, fp)
+		if err == nil {
+			// Make sure the range is on disk before recording it as done.
+			err = file.Sync()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("part %d: %w", part+1, err))
+			return
+		}
+		state.Done[part] = true
+		if err := saveDownloadState(statePath, state); err != nil {
+			errs = append(errs, err)
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writing to file: %w", err)
+	}
+	if dataKey != nil {
+		err := decryptFile(partialPath, destinationPath, dataKey)
+		// Either way the encrypted copy is of no more use.
+		os.Remove(partialPath)
+		if err != nil {
+			os.Remove(statePath)
+			return fmt.Errorf("decrypting file: %w", err)
+		}
+	} else if err := os.Rename(partialPath, destinationPath); err != nil {
+		return fmt.Errorf("moving download into place: %w", err)
+	}
+	os.Remove(statePath)
+
+	modTime, _ := objectModTime(head.Metadata, head.LastModified)
+	if err := os.Chtimes(destinationPath, modTime, modTime); err != nil {
+		return fmt.Errorf("setting modification time: %w", err)
+	}
+	return nil
+}
+
+// downloadRange writes the bytes of key from offset up to length bytes long,
+// or to the end of the object, into file at the same offset. The request is
+// conditional on etag so that a change to the object halfway through a
+// download is not silently mixed into it.
+func downloadRange(svc s3iface.S3API, bucket, key, versionID, etag string, file io.WriterAt, offset, length, size int64, fp *fileProgress) error {
+	end := offset + length - 1
+	if end >= size {
+		end = size - 1
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		IfMatch: aws.String(etag),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	output, err := svc.GetObject(input)
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+
+	n, err := io.Copy(io.NewOffsetWriter(file, offset), fp.reader(output.Body))
+	if err != nil {
+		return fmt.Errorf("writing to file: %w", err)
+	}
+	if n != end-offset+1 {
+		return fmt.Errorf("received %d of %d bytes", n, end-offset+1)
+	}
+	return nil
+}
+
+// loadDownloadState reads the progress of an earlier download, returning nil
+// if there is none or it cannot be read.
+func loadDownloadState(path string) *downloadState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+func saveDownloadState(path string, state *downloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("saving download progress: %w", err)
+	}
+	return nil
+}
+
+// fileDownload is an object key and the local path it is downloaded to.
+type fileDownload struct {
+	key  string
+	path string
+}
+
+// downloadFiles downloads every object, running up to opts.fileConcurrency
+// downloads at once, and records the outcome under each key.
+func downloadFiles(svc s3iface.S3API, bucket string, files []fileDownload, opts transferOptions, progress *transferProgress) *batchResult {
+	progress.expect(len(files), -1)
+	res := &batchResult{}
+	parallel(len(files), opts.fileConcurrency, func(i int) {
+		res.add(files[i].key, downloadFile(svc, bucket, files[i].key, "", files[i].path, opts, progress))
+	})
+	return res
+}
+
+// parallel calls fn for every index below count, with at most workers calls
+// running at the same time. It returns once every call has finished.
+func parallel(count, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
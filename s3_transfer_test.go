@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// testTransfer uses the smallest part size S3 allows, so that multipart
+// transfers stay small.
+var testTransfer = transferOptions{partSizeMiB: 5, concurrency: 3, fileConcurrency: 2}
+
+const testPartSize = 5 * 1024 * 1024
+
+// randomBytes returns n bytes that do not compress or repeat by part.
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range data {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		data[i] = byte(x)
+	}
+	return data
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{"empty", 0, false},
+		{"small", 1000, false},
+		{"multipart", 2*testPartSize + 123, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, svc, rec := newTestFake(t, "b")
+			dir := t.TempDir()
+			data := randomBytes(tt.size)
+			src := filepath.Join(dir, "src")
+			if err := os.WriteFile(src, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(src, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := uploadSingleFile(svc, "b", src, "dir/file", testTransfer, nil); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			multipart := false
+			for _, r := range rec.take() {
+				multipart = multipart || r.query == "uploads="
+			}
+			if multipart != tt.multipart {
+				t.Errorf("multipart upload = %v, want %v", multipart, tt.multipart)
+			}
+
+			dst := filepath.Join(dir, "out", "file")
+			if err := downloadSingleFile(svc, "b", "dir/file", dst, testTransfer, nil); err != nil {
+				t.Fatalf("download: %v", err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("downloaded %d bytes that differ from the %d uploaded", len(got), len(data))
+			}
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("modification time = %v, want %v", info.ModTime(), modTime)
+			}
+			if _, err := os.Stat(dst + partialSuffix); !os.IsNotExist(err) {
+				t.Errorf("partial download left behind: %v", err)
+			}
+		})
+	}
+}
+
+// rangeRequests counts the ranged reads among requests.
+func rangeRequests(requests []testRequest) int {
+	n := 0
+	for _, r := range requests {
+		if r.method == "GET" && r.query == "" && strings.Count(r.path, "/") > 1 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDownloadResume(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	dir := t.TempDir()
+	data := randomBytes(2*testPartSize + 10)
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadSingleFile(svc, "b", src, "big", testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("b"), Key: aws.String("big")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An earlier download got the first part and was interrupted.
+	dst := filepath.Join(dir, "big")
+	partial := make([]byte, len(data))
+	copy(partial, data[:testPartSize])
+	if err := os.WriteFile(dst+partialSuffix, partial, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: aws.StringValue(head.ETag), Size: int64(len(data)), PartSize: testPartSize, Done: []bool{true, false, false}}
+	if err := saveDownloadState(dst+partialSuffix+stateSuffix, state); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.take()
+	if err := downloadSingleFile(svc, "b", "big", dst, testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := rangeRequests(rec.take()); n != 2 {
+		t.Errorf("fetched %d ranges, want the 2 missing ones", n)
+	}
+	if !bytes.Equal([]byte(readTestFile(t, dst)), data) {
+		t.Fatal("resumed download differs from the object")
+	}
+	if _, err := os.Stat(dst + partialSuffix + stateSuffix); !os.IsNotExist(err) {
+		t.Errorf("download state left behind: %v", err)
+	}
+}
+
+func TestDownloadRestartsWhenObjectChanged(t *testing.T) {
+	_, svc, rec := newTestFake(t, "b")
+	dir := t.TempDir()
+	data := randomBytes(testPartSize + 10)
+	putTestObject(t, svc, "b", "obj", string(data))
+
+	dst := filepath.Join(dir, "obj")
+	if err := os.WriteFile(dst+partialSuffix, make([]byte, len(data)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stale := &downloadState{ETag: `"stale"`, Size: int64(len(data)), PartSize: testPartSize, Done: []bool{true, false}}
+	if err := saveDownloadState(dst+partialSuffix+stateSuffix, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.take()
+	if err := downloadSingleFile(svc, "b", "obj", dst, testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := rangeRequests(rec.take()); n != 2 {
+		t.Errorf("fetched %d ranges, want all 2", n)
+	}
+	if !bytes.Equal([]byte(readTestFile(t, dst)), data) {
+		t.Fatal("download differs from the object")
+	}
+}
+
+func TestUploadAndDownloadMultipleFiles(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+
+	res, err := uploadMultipleFiles(svc, "b", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "missing")}, "in", testTransfer, nil)
+	if err == nil || len(res.succeeded) != 2 || len(res.failed) != 1 {
+		t.Fatalf("upload: %v, %d succeeded, %d failed", err, len(res.succeeded), len(res.failed))
+	}
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"in/a.txt", "in/b.txt"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	progress := newTransferProgress("Downloaded")
+	progress.out = &bytes.Buffer{}
+	res, err = downloadMultipleFiles(svc, "b", map[string]string{
+		"in/a.txt": filepath.Join(dir, "out", "a"),
+		"in/b.txt": filepath.Join(dir, "out", "b"),
+	}, testTransfer, progress)
+	if err != nil || len(res.succeeded) != 2 {
+		t.Fatalf("download: %v, %d succeeded", err, len(res.succeeded))
+	}
+	if got := readTestFile(t, filepath.Join(dir, "out", "b")); got != "bravo" {
+		t.Errorf("downloaded %q", got)
+	}
+	if summary := progress.summary(); len(summary.files) != 2 {
+		t.Errorf("progress tracked %d files, want 2", len(summary.files))
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// objectVersion is one version of a key, or a delete marker.
+type objectVersion struct {
+	key          string
+	versionID    string
+	deleteMarker bool
+	latest       bool
+	size         int64
+	etag         string
+	lastModified time.Time
+}
+
+// getVersioning returns the versioning status of bucket: "Enabled",
+// "Suspended", or "" if versioning has never been enabled.
+func getVersioning(svc s3iface.S3API, bucket string) (string, error) {
+	result, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(result.Status), nil
+}
+
+// setVersioning enables or suspends versioning on bucket. Suspending keeps
+// the existing versions; new writes replace the null version.
+func setVersioning(svc s3iface.S3API, bucket string, enabled bool) error {
+	status := s3.BucketVersioningStatusSuspended
+	if enabled {
+		status = s3.BucketVersioningStatusEnabled
+	}
+	_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(status)},
+	})
+	return err
+}
+
+// listVersions returns the versions and delete markers of target, sorted by
+// key and newest first. A target ending in a slash, or an empty one, is a
+// prefix; anything else is an exact key.
+func listVersions(svc s3iface.S3API, bucket, target string) ([]objectVersion, error) {
+	exact := target != "" && !strings.HasSuffix(target, "/")
+	var versions []objectVersion
+	err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(target),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range page.Versions {
+			if exact && aws.StringValue(v.Key) != target {
+				continue
+			}
+			versions = append(versions, objectVersion{
+				key:          aws.StringValue(v.Key),
+				versionID:    aws.StringValue(v.VersionId),
+				latest:       aws.BoolValue(v.IsLatest),
+				size:         aws.Int64Value(v.Size),
+				etag:         aws.StringValue(v.ETag),
+				lastModified: aws.TimeValue(v.LastModified),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			if exact && aws.StringValue(m.Key) != target {
+				continue
+			}
+			versions = append(versions, objectVersion{
+				key:          aws.StringValue(m.Key),
+				versionID:    aws.StringValue(m.VersionId),
+				deleteMarker: true,
+				latest:       aws.BoolValue(m.IsLatest),
+				lastModified: aws.TimeValue(m.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing object versions: %w", err)
+	}
+
+	// Versions and delete markers come in separate lists, so interleave them
+	// again. The latest one goes first even if its date ties with another.
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, b := versions[i], versions[j]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		if a.latest != b.latest {
+			return a.latest
+		}
+		return a.lastModified.After(b.lastModified)
+	})
+	return versions, nil
+}
+
+// downloadVersion downloads one version of key to destinationPath.
+func downloadVersion(svc s3iface.S3API, bucket, key, versionID, destinationPath string, opts transferOptions, progress *transferProgress) error {
+	progress.expect(1, -1)
+	return downloadFile(svc, bucket, key, versionID, destinationPath, opts, progress)
+}
+
+// restoreVersion makes a prior version of key the latest again by copying it
+// over the key, together with its metadata. The versions in between are
+// kept.
+func restoreVersion(svc s3iface.S3API, bucket, key, versionID string) error {
+	return copyObjectVersion(svc, bucket, key, versionID, bucket, key)
+}
+
+// undelete brings back deleted objects by removing the delete marker that
+// hides each of them. Keys under target whose latest version is a delete
+// marker are undeleted, where target is a key or a prefix as for
+// listVersions; keys that are not deleted are left alone.
+func undelete(svc s3iface.S3API, bucket, target string) (*batchResult, error) {
+	versions, err := listVersions(svc, bucket, target)
+	if err != nil {
+		return &batchResult{}, err
+	}
+
+	res := &batchResult{}
+	for _, v := range versions {
+		if !v.latest || !v.deleteMarker {
+			continue
+		}
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(v.key),
+			VersionId: aws.String(v.versionID),
+		})
+		res.add(v.key, err)
+	}
+	return res, res.err()
+}
+
+// restoreAction is one change that rolls a key back to its state at the
+// restore time.
+type restoreAction struct {
+	kind      string // "restore" or "delete"
+	key       string
+	versionID string // the version copied back, for "restore"
+	size      int64
+}
+
+// restorePlan lists the changes that bring the keys under a prefix back to
+// how they were at a point in time.
+type restorePlan struct {
+	bucket    string
+	target    string
+	at        time.Time
+	actions   []restoreAction
+	unchanged int
+}
+
+// timestampLayouts are the accepted forms of a restore time. Those without a
+// zone are read as UTC, the zone versions are listed in.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseTimestamp reads a restore time in one of timestampLayouts.
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q; use RFC 3339 or YYYY-MM-DD HH:MM:SS (UTC)", s)
+}
+
+// planRestore works out, for every key under target (a key or a prefix as for
+// listVersions), the version that was current at the given time. Keys whose
+// latest version differs get that version copied back; keys that did not
+// exist then, or were deleted, are deleted again. Nothing is changed.
+func planRestore(svc s3iface.S3API, bucket, target string, at time.Time) (*restorePlan, error) {
+	versions, err := listVersions(svc, bucket, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Listed times are shown to the second, so a time without a fraction
+	// covers the whole second: a version written at 10:00:00.5 was current
+	// at "10:00:00".
+	cutoff := at
+	if at.Nanosecond() == 0 {
+		cutoff = at.Add(time.Second - 1)
+	}
+
+	plan := &restorePlan{bucket: bucket, target: target, at: at}
+	for i := 0; i < len(versions); {
+		key := versions[i].key
+		latest := versions[i]
+		var then *objectVersion
+		for ; i < len(versions) && versions[i].key == key; i++ {
+			if then == nil && !versions[i].lastModified.After(cutoff) {
+				then = &versions[i]
+			}
+		}
+
+		switch {
+		case then == nil || then.deleteMarker:
+			if latest.deleteMarker {
+				plan.unchanged++
+				continue
+			}
+			plan.actions = append(plan.actions, restoreAction{kind: "delete", key: key, size: latest.size})
+		case then.versionID == latest.versionID, sameVersionContent(*then, latest):
+			plan.unchanged++
+		default:
+			plan.actions = append(plan.actions, restoreAction{kind: "restore", key: key, versionID: then.versionID, size: then.size})
+		}
+	}
+	return plan, nil
+}
+
+// sameVersionContent reports whether two versions hold the same data, as after an
+// earlier restore copied one over the key.
+func sameVersionContent(a, b objectVersion) bool {
+	return !a.deleteMarker && !b.deleteMarker && a.etag != "" && a.etag == b.etag && a.size == b.size
+}
+
+// applyRestore makes the changes in plan. Restored versions are copied over
+// their keys and deleted keys get a delete marker, so the restore itself can
+// be undone from the versions it leaves behind.
+func applyRestore(svc s3iface.S3API, plan *restorePlan) *batchResult {
+	res := &batchResult{}
+	for _, action := range plan.actions {
+		var err error
+		switch action.kind {
+		case "restore":
+			err = restoreVersion(svc, plan.bucket, action.key, action.versionID)
+		case "delete":
+			_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(plan.bucket),
+				Key:    aws.String(action.key),
+			})
+		}
+		res.add(action.key, err)
+	}
+	return res
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dateVersions sets the times the versions of key were written, oldest
+// first.
+func dateVersions(f *fakeS3, bucket, key string, times ...time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, v := range f.buckets[bucket].objects[key] {
+		v.lastModified = times[i]
+	}
+}
+
+func TestVersions(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if status, err := getVersioning(svc, "b"); err != nil || status != "" {
+		t.Fatalf("versioning of a new bucket = %q, %v", status, err)
+	}
+	if err := setVersioning(svc, "b", true); err != nil {
+		t.Fatal(err)
+	}
+	first := putTestObject(t, svc, "b", "doc", "one")
+	putTestObject(t, svc, "b", "doc", "two")
+
+	versions, err := listVersions(svc, "b", "doc")
+	if err != nil || len(versions) != 2 || !versions[0].latest || versions[1].versionID != *first.VersionId {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "doc")
+	if err := downloadVersion(svc, "b", "doc", *first.VersionId, dst, testTransfer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dst); got != "one" {
+		t.Errorf("first version holds %q", got)
+	}
+
+	if err := restoreVersion(svc, "b", "doc", *first.VersionId); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readTestObject(t, svc, "b", "doc"); got != "one" {
+		t.Errorf("after restoring, doc = %q", got)
+	}
+	if versions, _ := listVersions(svc, "b", "doc"); len(versions) != 3 {
+		t.Errorf("restoring left %d versions, want 3", len(versions))
+	}
+
+	if err := deleteSingleFile(svc, "b", "doc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readTestObject(t, svc, "b", "doc"); ok {
+		t.Fatal("doc still readable after deleting it")
+	}
+	res, err := undelete(svc, "b", "")
+	if err != nil || len(res.succeeded) != 1 {
+		t.Fatalf("undelete: %v", err)
+	}
+	if got, _ := readTestObject(t, svc, "b", "doc"); got != "one" {
+		t.Errorf("after undeleting, doc = %q", got)
+	}
+}
+
+func TestRestoreToPointInTime(t *testing.T) {
+	f, svc, _ := newTestFake(t, "b")
+	if err := setVersioning(svc, "b", true); err != nil {
+		t.Fatal(err)
+	}
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 12, 0, 0, 0, time.UTC) }
+
+	putTestObject(t, svc, "b", "dir/changed", "old")
+	putTestObject(t, svc, "b", "dir/changed", "new")
+	dateVersions(f, "b", "dir/changed", day(1), day(3))
+	putTestObject(t, svc, "b", "dir/created", "x")
+	dateVersions(f, "b", "dir/created", day(3))
+	putTestObject(t, svc, "b", "dir/deleted", "kept")
+	if err := deleteSingleFile(svc, "b", "dir/deleted"); err != nil {
+		t.Fatal(err)
+	}
+	dateVersions(f, "b", "dir/deleted", day(1), day(3))
+	putTestObject(t, svc, "b", "dir/same", "same")
+	dateVersions(f, "b", "dir/same", day(1))
+	putTestObject(t, svc, "b", "other", "x")
+	dateVersions(f, "b", "other", day(3))
+
+	at, err := parseTimestamp("2024-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := planRestore(svc, "b", "dir/", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, action := range plan.actions {
+		got = append(got, action.kind+" "+action.key)
+	}
+	if want := []string{"restore dir/changed", "delete dir/created", "restore dir/deleted"}; !equalStrings(got, want) || plan.unchanged != 1 {
+		t.Fatalf("plan = %q with %d unchanged, want %q with 1", got, plan.unchanged, want)
+	}
+	if err := applyRestore(svc, plan).err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := testKeys(t, svc, "b", ""); !equalStrings(keys, []string{"dir/changed", "dir/deleted", "dir/same", "other"}) {
+		t.Errorf("keys = %v", keys)
+	}
+	for key, want := range map[string]string{"dir/changed": "old", "dir/deleted": "kept"} {
+		if got, _ := readTestObject(t, svc, "b", key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	plan, err = planRestore(svc, "b", "dir/", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.actions) != 0 || plan.unchanged != 4 {
+		t.Errorf("planning again gave %d changes and %d unchanged, want 0 and 4", len(plan.actions), plan.unchanged)
+	}
+}
+
+func TestCopyVersionsThatNeedEscaping(t *testing.T) {
+	_, svc, _ := newTestFake(t, "b")
+	if err := setVersioning(svc, "b", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a?versionId=x", "100% sure", "c++/x y#z", "ünï/çødé"} {
+		first := putTestObject(t, svc, "b", key, "v1")
+		putTestObject(t, svc, "b", key, "v2")
+
+		if err := copyObjectVersion(svc, "b", key, *first.VersionId, "b", "old/"+key); err != nil {
+			t.Fatalf("copying the first version of %q: %v", key, err)
+		}
+		if got, _ := readTestObject(t, svc, "b", "old/"+key); got != "v1" {
+			t.Errorf("copy of the first version of %q holds %q", key, got)
+		}
+
+		bucket, parsedKey, versionID := parseCopySource(copySource("b", key, "v+1/="))
+		if bucket != "b" || parsedKey != key || versionID != "v+1/=" {
+			t.Errorf("copy source of %q parsed as %q, %q, %q", key, bucket, parsedKey, versionID)
+		}
+	}
+}
@@ -4,55 +4,136 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 func main() {
-	reader := bufio.NewReader(os.Stdin)
+	os.Exit(run(os.Args[1:]))
+}
+
+// app is the state shared by the interactive actions and the subcommands.
+// bucket is only set in the interactive menu. reader is the only reader of
+// standard input, shared with the session's MFA prompts.
+type app struct {
+	svc     *regionRouter
+	clients *clientFactory
+	opts    *options
+	bucket  string
+	reader  *bufio.Reader
+
+	// dryRun records the writes of the current action while dry run is on.
+	dryRun *dryRunClient
+}
 
-	fmt.Print("Enter AWS Key ID: ")
-	awsKeyID, _ := reader.ReadString('\n')
+func newApp(sess *session.Session, opts *options, reader *bufio.Reader) *app {
+	clients := newClientFactory(sess, opts.session)
+	return &app{
+		svc:     newRegionRouter(clients, aws.StringValue(sess.Config.Region)),
+		clients: clients,
+		opts:    opts,
+		reader:  reader,
+	}
+}
 
-	fmt.Print("Enter AWS Secret Key: ")
-	awsSecretKey, _ := reader.ReadString('\n')
+// setRegion selects region for calls that do not name a bucket, such as
+// listing and creating buckets. Bucket operations keep going to the region of
+// their bucket.
+func (a *app) setRegion(region string) {
+	a.svc = newRegionRouter(a.clients, region)
+}
 
-	fmt.Print("Enter AWS Region (e.g., eu-west-2): ")
-	awsRegion, _ := reader.ReadString('\n')
+// client returns the client actions use: the S3 client itself or, while dry
+// run is on, a client that only records writes. Either refuses to delete
+// protected buckets and prefixes.
+func (a *app) client() s3iface.S3API {
+	svc := a.base()
+	if a.dryRun != nil {
+		svc = a.dryRun
+	}
+	if len(a.opts.protected) > 0 {
+		svc = &protectedClient{S3API: svc, protected: a.opts.protected}
+	}
+	return svc
+}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(strings.TrimSpace(awsRegion)),
-		Credentials: credentials.NewStaticCredentials(strings.TrimSpace(awsKeyID), strings.TrimSpace(awsSecretKey), ""),
-	})
+// base returns the client below the dry-run and protection layers: the
+// region router, with the configured object encryption added.
+func (a *app) base() s3iface.S3API {
+	return newEncryptingClient(a.svc, a.opts.encryption)
+}
+
+// newProgress returns a tracker for a batch transfer, or nil during a dry
+// run, which transfers nothing.
+func (a *app) newProgress(verb string) *transferProgress {
+	if a.opts.dryRun {
+		return nil
+	}
+	return newTransferProgress(verb)
+}
+
+// do runs one action or subcommand. When dry run is on, writes are only
+// recorded and listed afterwards.
+func (a *app) do(fn func() error) error {
+	if !a.opts.dryRun {
+		return fn()
+	}
+	a.dryRun = newDryRunClient(a.base())
+	defer func() {
+		if len(a.dryRun.changes) > 0 {
+			printDryRun(a.dryRun.changes)
+		}
+		a.dryRun = nil
+	}()
+	return fn()
+}
+
+// interactive runs the numbered prompt loop until the user chooses to exit.
+func interactive(opts *options) int {
+	reader := bufio.NewReader(os.Stdin)
+
+	sess, err := newSession(opts.session, reader, true)
 	if err != nil {
 		fmt.Println("Error creating session:", err)
-		return
+		return exitError
 	}
 
-	svc := s3.New(sess)
-
-	fmt.Print("Do you want to create a new bucket? (yes/no): ")
-	createBucketChoice, _ := reader.ReadString('\n')
-	createBucketChoice = strings.TrimSpace(createBucketChoice)
+	a := newApp(sess, opts, reader)
 
+	// Ask until there is a bucket to work in; a bucket that could not be
+	// created is not one.
 	var bucket string
-	if createBucketChoice == "yes" {
-		fmt.Print("Enter new bucket name: ")
-		bucket, _ = reader.ReadString('\n')
-		bucket = strings.TrimSpace(bucket)
-		createBucket(svc, bucket)
-	} else {
-		fmt.Print("Enter existing bucket name: ")
-		bucket, _ = reader.ReadString('\n')
-		bucket = strings.TrimSpace(bucket)
+	for bucket == "" {
+		fmt.Print("Do you want to create a new bucket? (yes/no): ")
+		createBucketChoice, err := reader.ReadString('\n')
+		createBucketChoice = strings.TrimSpace(createBucketChoice)
+		if err != nil && createBucketChoice == "" {
+			fmt.Println()
+			return exitError
+		}
+
+		if createBucketChoice == "yes" {
+			name := a.ask("Enter new bucket name: ")
+			opts := a.promptBucketOptions()
+			err := a.do(func() error {
+				return report(createBucket(a.client(), name, opts), "Error creating bucket", "Bucket created successfully.")
+			})
+			if err == nil {
+				bucket = name
+			}
+		} else {
+			bucket = a.ask("Enter existing bucket name: ")
+		}
 	}
 
-	actions := map[string]func(*s3.S3, string, *bufio.Reader){
+	a.bucket = bucket
+	actions := map[string]func(*app){
 		"1":  createFolderAction,
 		"2":  uploadSingleFileAction,
 		"3":  uploadMultipleFilesAction,
@@ -74,9 +155,26 @@ func main() {
 		"19": moveFoldersAction,
 		"20": renameFoldersAction,
 		"21": generatePreSignedURLAction,
+		"22": uploadFolderAction,
+		"23": downloadFolderAction,
+		"24": syncFoldersAction,
+		"25": toggleDryRunAction,
+		"26": switchBucketAction,
+		"27": bucketVersioningAction,
+		"28": listVersionsAction,
+		"29": downloadVersionAction,
+		"30": restoreVersionAction,
+		"31": undeleteAction,
+		"32": restoreToTimeAction,
+		"33": lifecycleAction,
+		"34": bucketEncryptionAction,
 	}
 
 	for {
+		fmt.Printf("Bucket: %s  Region: %s\n", a.bucket, a.svc.bucketRegion(a.bucket))
+		if opts.dryRun {
+			fmt.Println("Dry run is on: changes are listed, not made.")
+		}
 		fmt.Println("Choose an option:")
 		fmt.Printf("%-30s %-30s %-30s\n", "1. Create a folder", "2. Upload a single file", "3. Upload multiple files")
 		fmt.Printf("%-30s %-30s %-30s\n", "4. Delete a single file", "5. Delete multiple files", "6. Delete a folder")
@@ -85,69 +183,494 @@ func main() {
 		fmt.Printf("%-30s %-30s %-30s\n", "13. Delete Bucket Policy", "14. Set Bucket ACL", "15. Delete Bucket")
 		fmt.Printf("%-30s %-30s %-30s\n", "16. Set a Region", "17. Move a File", "18. Rename a File")
 		fmt.Printf("%-30s %-30s %-30s\n", "19. Move a Folder", "20. Rename a Folder", "21. Generate a Pre-signed URL")
-		fmt.Println("22. Exit")
+		fmt.Printf("%-30s %-30s %-30s\n", "22. Upload a folder", "23. Download a folder", "24. Sync folders")
+		fmt.Printf("%-30s %-30s %-30s\n", "25. Toggle dry run", "26. Switch bucket", "27. Bucket versioning")
+		fmt.Printf("%-30s %-30s %-30s\n", "28. List object versions", "29. Download a version", "30. Restore a version")
+		fmt.Printf("%-30s %-30s %-30s\n", "31. Undelete objects", "32. Restore a folder to a time", "33. Edit lifecycle rules")
+		fmt.Printf("%-30s %-30s\n", "34. Bucket encryption", "35. Exit")
 		fmt.Print("Enter your choice: ")
 		choice, _ := reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 
 		action, exists := actions[choice]
 		if exists {
-			action(svc, bucket, reader)
-		} else if choice == "22" {
-			return
+			a.do(func() error {
+				action(a)
+				return nil
+			})
+		} else if choice == "35" {
+			return exitOK
 		} else {
 			fmt.Println("Invalid choice. Please try again.")
 		}
 	}
 }
 
-func createFolderAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+// ask prints question and returns the user's trimmed answer.
+func (a *app) ask(question string) string {
+	fmt.Print(question)
+	answer, _ := a.reader.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// promptBucketOptions asks for the settings of a new bucket, which is
+// created in the selected region.
+func (a *app) promptBucketOptions() bucketOptions {
+	opts := bucketOptions{region: a.svc.region()}
+	if a.ask("Configure object lock, ownership, ACL, encryption, versioning or public access? (yes/no): ") != "yes" {
+		return opts
+	}
+	opts.objectLock = a.ask("Enable object lock? (yes/no): ") == "yes"
+	opts.ownership = a.ask("Object ownership (" + strings.Join(validOwnerships, ", ") + "; leave empty for the default): ")
+	opts.acl = a.ask("Initial ACL (leave empty for the default): ")
+	opts.encryption = a.ask("Default encryption (" + strings.Join(validEncryptions, ", ") + "; leave empty for the default): ")
+	if opts.encryption == s3.ServerSideEncryptionAwsKms {
+		opts.kmsKeyID = a.ask("KMS key ID (leave empty for the AWS managed key): ")
+		opts.bucketKey = a.ask("Use an S3 bucket key to reduce KMS requests? (yes/no): ") == "yes"
+	}
+	if !opts.objectLock {
+		opts.versioning = a.ask("Enable versioning? (yes/no): ") == "yes"
+	}
+	opts.blockPublicAccess = a.ask("Block all public access? (yes/no): ") == "yes"
+	return opts
+}
+
+func createFolderAction(a *app) {
 	fmt.Print("Enter folder name: ")
-	folder, _ := reader.ReadString('\n')
-	createFolder(svc, bucket, strings.TrimSpace(folder))
+	folder, _ := a.reader.ReadString('\n')
+	report(createFolder(a.client(), a.bucket, strings.TrimSpace(folder)), "Error creating folder", "Folder created successfully.")
 }
 
-func uploadSingleFileAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func uploadSingleFileAction(a *app) {
 	fmt.Print("Enter file path: ")
-	filePath, _ := reader.ReadString('\n')
-	uploadSingleFile(svc, bucket, strings.TrimSpace(filePath))
+	filePath, _ := a.reader.ReadString('\n')
+	filePath = strings.TrimSpace(filePath)
+
+	fmt.Printf("Enter object key (leave empty for %s): ", filepath.Base(filePath))
+	key, _ := a.reader.ReadString('\n')
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = filepath.Base(filePath)
+	}
+
+	progress := a.newProgress("Uploaded")
+	err := uploadSingleFile(a.client(), a.bucket, filePath, key, a.opts.transfer, progress)
+	progress.stop()
+	report(err, "Error uploading file", "File uploaded successfully.")
 }
 
-func uploadMultipleFilesAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func uploadMultipleFilesAction(a *app) {
 	fmt.Print("Enter file paths (comma-separated): ")
-	filePaths, _ := reader.ReadString('\n')
-	uploadMultipleFiles(svc, bucket, strings.TrimSpace(filePaths))
+	filePaths, _ := a.reader.ReadString('\n')
+	fmt.Print("Enter destination folder (leave empty for the bucket root): ")
+	prefix, _ := a.reader.ReadString('\n')
+	progress := a.newProgress("Uploaded")
+	res, err := uploadMultipleFiles(a.client(), a.bucket, splitList(filePaths), strings.TrimSpace(prefix), a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error uploading file", "%d files uploaded successfully.")
+}
+
+func uploadFolderAction(a *app) {
+	fmt.Print("Enter local folder path: ")
+	localDir, _ := a.reader.ReadString('\n')
+	localDir = strings.TrimSpace(localDir)
+
+	fmt.Print("Enter destination folder (leave empty for the bucket root): ")
+	prefix, _ := a.reader.ReadString('\n')
+
+	var opts directoryUploadOptions
+	fmt.Print("Enter patterns of files to include (comma-separated, leave empty for all files): ")
+	include, _ := a.reader.ReadString('\n')
+	if strings.TrimSpace(include) != "" {
+		opts.include = splitList(include)
+	}
+
+	fmt.Print("Enter patterns of files or folders to exclude (comma-separated, leave empty for none): ")
+	exclude, _ := a.reader.ReadString('\n')
+	if strings.TrimSpace(exclude) != "" {
+		opts.exclude = splitList(exclude)
+	}
+
+	fmt.Print("Follow symbolic links? (yes/no): ")
+	follow, _ := a.reader.ReadString('\n')
+	opts.followSymlinks = strings.TrimSpace(follow) == "yes"
+
+	progress := a.newProgress("Uploaded")
+	res, err := uploadDirectory(a.client(), a.bucket, localDir, strings.TrimSpace(prefix), opts, a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error uploading file", "Folder uploaded successfully (%d files).")
+}
+
+func downloadFolderAction(a *app) {
+	fmt.Print("Enter folder to download (leave empty for the whole bucket): ")
+	prefix, _ := a.reader.ReadString('\n')
+
+	fmt.Print("Enter local destination folder: ")
+	localDir, _ := a.reader.ReadString('\n')
+
+	fmt.Print("When a file already exists (skip/overwrite/rename): ")
+	answer, _ := a.reader.ReadString('\n')
+	conflict, err := parseConflictPolicy(strings.TrimSpace(answer))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	progress := a.newProgress("Downloaded")
+	res, err := downloadDirectory(a.client(), a.bucket, strings.TrimSpace(prefix), strings.TrimSpace(localDir), conflict, a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error downloading file", "Folder downloaded successfully (%d files).")
+}
+
+func syncFoldersAction(a *app) {
+	fmt.Printf("Enter source (local folder or s3://bucket/prefix, e.g. s3://%s/data): ", a.bucket)
+	source, _ := a.reader.ReadString('\n')
+	fmt.Print("Enter destination (local folder or s3://bucket/prefix): ")
+	target, _ := a.reader.ReadString('\n')
+
+	var opts syncOptions
+	fmt.Print("Delete files in the destination that are not in the source? (yes/no): ")
+	answer, _ := a.reader.ReadString('\n')
+	opts.delete = strings.TrimSpace(answer) == "yes"
+	fmt.Print("Compare files by checksum instead of modification time? (yes/no): ")
+	answer, _ = a.reader.ReadString('\n')
+	opts.checksum = strings.TrimSpace(answer) == "yes"
+
+	plan, err := planSync(a.client(), parseSyncLocation(strings.TrimSpace(source)), parseSyncLocation(strings.TrimSpace(target)), opts, a.opts.transfer)
+	if err != nil {
+		fmt.Println("Error syncing:", err)
+		return
+	}
+	printSyncPlan(plan)
+	if len(plan.actions) == 0 {
+		fmt.Println("Nothing to do.")
+		return
+	}
+	if a.opts.dryRun {
+		return
+	}
+
+	fmt.Print("Apply these changes? (yes/no): ")
+	answer, _ = a.reader.ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		fmt.Println("Sync cancelled.")
+		return
+	}
+	progress := a.newProgress("Synced")
+	res := applySync(a.client(), plan, a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, res.err(), "Error syncing", "Sync complete (%d changes).")
+}
+
+func toggleDryRunAction(a *app) {
+	a.opts.dryRun = !a.opts.dryRun
+	if a.opts.dryRun {
+		fmt.Println("Dry run turned on.")
+	} else {
+		fmt.Println("Dry run turned off.")
+	}
+}
+
+// switchBucketAction makes another bucket the one the actions work on,
+// chosen by number from the buckets matching a filter or typed by name.
+func switchBucketAction(a *app) {
+	fmt.Print("Filter bucket names (leave empty for all): ")
+	filter, _ := a.reader.ReadString('\n')
+	names, err := listBucketNames(a.client(), strings.TrimSpace(filter))
+	if err != nil {
+		fmt.Println("Error listing buckets:", err)
+	} else if len(names) == 0 {
+		fmt.Println("No buckets match.")
+	} else {
+		printBucketNames(names, a.bucket)
+	}
+
+	fmt.Print("Enter a number or bucket name (leave empty to keep the current bucket): ")
+	choice, _ := a.reader.ReadString('\n')
+	choice = strings.TrimSpace(choice)
+	if choice == "" {
+		return
+	}
+	if n, err := strconv.Atoi(choice); err == nil {
+		if n < 1 || n > len(names) {
+			fmt.Println("Invalid choice.")
+			return
+		}
+		choice = names[n-1]
+	}
+
+	a.bucket = choice
+	fmt.Printf("Switched to bucket %s in %s.\n", a.bucket, a.svc.bucketRegion(a.bucket))
+}
+
+// bucketVersioningAction shows the versioning status of the current bucket
+// and offers to enable or suspend it.
+func bucketVersioningAction(a *app) {
+	status, err := getVersioning(a.client(), a.bucket)
+	if err != nil {
+		fmt.Println("Error getting bucket versioning:", err)
+		return
+	}
+	printVersioning(status)
+
+	fmt.Print("Enable, suspend or leave versioning as it is? (enable/suspend/leave): ")
+	choice, _ := a.reader.ReadString('\n')
+	switch strings.TrimSpace(choice) {
+	case "enable":
+		report(setVersioning(a.client(), a.bucket, true), "Error enabling versioning", "Versioning enabled.")
+	case "suspend":
+		report(setVersioning(a.client(), a.bucket, false), "Error suspending versioning", "Versioning suspended.")
+	}
+}
+
+func listVersionsAction(a *app) {
+	fmt.Print("Enter a file key, or a prefix ending in / (leave empty for the whole bucket): ")
+	target, _ := a.reader.ReadString('\n')
+	versions, err := listVersions(a.client(), a.bucket, strings.TrimSpace(target))
+	if err != nil {
+		fmt.Println("Error listing versions:", err)
+		return
+	}
+	printVersions(versions)
+}
+
+func downloadVersionAction(a *app) {
+	fmt.Print("Enter file key: ")
+	fileKey, _ := a.reader.ReadString('\n')
+	fmt.Print("Enter version ID: ")
+	versionID, _ := a.reader.ReadString('\n')
+	fmt.Print("Enter destination path: ")
+	destinationPath, _ := a.reader.ReadString('\n')
+	progress := a.newProgress("Downloaded")
+	err := downloadVersion(a.client(), a.bucket, strings.TrimSpace(fileKey), strings.TrimSpace(versionID), strings.TrimSpace(destinationPath), a.opts.transfer, progress)
+	progress.stop()
+	report(err, "Error downloading version", "Version downloaded successfully.")
 }
 
-func deleteSingleFileAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func restoreVersionAction(a *app) {
 	fmt.Print("Enter file key: ")
-	fileKey, _ := reader.ReadString('\n')
-	deleteSingleFile(svc, bucket, strings.TrimSpace(fileKey))
+	fileKey, _ := a.reader.ReadString('\n')
+	fmt.Print("Enter the version ID to restore: ")
+	versionID, _ := a.reader.ReadString('\n')
+	err := restoreVersion(a.client(), a.bucket, strings.TrimSpace(fileKey), strings.TrimSpace(versionID))
+	report(err, "Error restoring version", "Version restored successfully.")
+}
+
+func undeleteAction(a *app) {
+	fmt.Print("Enter a file key, or a prefix ending in /: ")
+	target, _ := a.reader.ReadString('\n')
+	res, err := undelete(a.client(), a.bucket, strings.TrimSpace(target))
+	reportBatch(res, err, "Error undeleting object", "%d objects undeleted.")
+}
+
+// restoreToTimeAction rolls a folder back to how it was at a point in time,
+// after showing the changes that takes.
+func restoreToTimeAction(a *app) {
+	fmt.Print("Enter folder prefix (e.g. reports/): ")
+	prefix, _ := a.reader.ReadString('\n')
+	prefix = strings.TrimSpace(prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		// A bare folder name would also match its siblings, such as
+		// reports-old/ for reports.
+		prefix += "/"
+	}
+	fmt.Print("Enter the time to restore to (YYYY-MM-DD HH:MM:SS in UTC, or RFC 3339): ")
+	timestamp, _ := a.reader.ReadString('\n')
+	at, err := parseTimestamp(strings.TrimSpace(timestamp))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	plan, err := planRestore(a.client(), a.bucket, prefix, at)
+	if err != nil {
+		fmt.Println("Error planning restore:", err)
+		return
+	}
+	printRestorePlan(plan)
+	if len(plan.actions) == 0 {
+		fmt.Println("Nothing to do.")
+		return
+	}
+	if a.opts.dryRun || a.confirmRestore(plan) != nil {
+		return
+	}
+	res := applyRestore(a.client(), plan)
+	reportBatch(res, res.err(), "Error restoring", "Restore complete (%d changes).")
 }
 
-func deleteMultipleFilesAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+// bucketEncryptionAction shows the default encryption of the current bucket
+// and offers to change or delete it.
+func bucketEncryptionAction(a *app) {
+	enc, err := getBucketEncryption(a.client(), a.bucket)
+	if err != nil {
+		fmt.Println("Error getting bucket encryption:", err)
+		return
+	}
+	printBucketEncryption(enc)
+
+	switch a.ask("Set, delete or leave the default encryption as it is? (set/delete/leave): ") {
+	case "set":
+		enc := bucketEncryption{algorithm: a.ask("Encryption (" + strings.Join(validEncryptions, ", ") + "): ")}
+		if enc.algorithm == s3.ServerSideEncryptionAwsKms {
+			enc.kmsKeyID = a.ask("KMS key ID (leave empty for the AWS managed key): ")
+			enc.bucketKey = a.ask("Use an S3 bucket key to reduce KMS requests? (yes/no): ") == "yes"
+		}
+		report(setBucketEncryption(a.client(), a.bucket, enc), "Error setting bucket encryption", "Bucket encryption set successfully.")
+	case "delete":
+		report(deleteBucketEncryption(a.client(), a.bucket), "Error deleting bucket encryption", "Bucket encryption deleted successfully.")
+	}
+}
+
+// lifecycleAction edits the lifecycle rules of the current bucket. Rules are
+// added, removed, loaded and saved locally and only sent to S3 when applied.
+func lifecycleAction(a *app) {
+	rules, err := getLifecycle(a.client(), a.bucket)
+	if err != nil {
+		fmt.Println("Error getting lifecycle rules:", err)
+		return
+	}
+	changed := false
+	for {
+		printLifecycle(rules)
+		fmt.Println("1. Add a rule  2. Remove a rule  3. Load rules from JSON  4. Save rules to JSON")
+		fmt.Println("5. Apply the rules to the bucket  6. Delete the bucket's lifecycle configuration  7. Done")
+		switch a.ask("Enter your choice: ") {
+		case "1":
+			rule, err := a.promptLifecycleRule()
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			rules = append(rules, rule)
+			changed = true
+		case "2":
+			n, err := strconv.Atoi(a.ask("Enter the number of the rule to remove: "))
+			if err != nil || n < 1 || n > len(rules) {
+				fmt.Println("Invalid choice.")
+				continue
+			}
+			rules = append(rules[:n-1], rules[n:]...)
+			changed = true
+		case "3":
+			loaded, err := loadLifecycle(a.ask("Enter JSON file path: "))
+			if err != nil {
+				fmt.Println("Error reading lifecycle rules:", err)
+				continue
+			}
+			rules = loaded
+			changed = true
+		case "4":
+			path := a.ask("Enter JSON file path: ")
+			report(saveLifecycle(rules, path), "Error saving lifecycle rules", "Lifecycle rules saved to "+path+".")
+		case "5":
+			if report(putLifecycle(a.client(), a.bucket, rules), "Error setting lifecycle rules", "Lifecycle rules set successfully.") == nil {
+				changed = false
+			}
+		case "6":
+			if report(deleteLifecycle(a.client(), a.bucket), "Error deleting lifecycle rules", "Lifecycle rules deleted successfully.") == nil {
+				rules, changed = nil, false
+			}
+		case "7":
+			if changed && a.ask("Discard the changes that were not applied? (yes/no): ") != "yes" {
+				continue
+			}
+			return
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+		}
+	}
+}
+
+// promptLifecycleRule asks for the filter and actions of a new lifecycle
+// rule.
+func (a *app) promptLifecycleRule() (*s3.LifecycleRule, error) {
+	spec := lifecycleRuleSpec{id: a.ask("Rule ID: ")}
+	spec.prefix = a.ask("Apply to keys starting with (leave empty for all keys): ")
+	tags, err := parseTags(a.ask("Apply only to objects with these tags (key=value, comma-separated; leave empty for any): "))
+	if err != nil {
+		return nil, err
+	}
+	spec.tags = tags
+	spec.transitions, err = parseTransitions(a.ask("Transitions (days:STORAGE_CLASS, comma-separated, e.g. 30:STANDARD_IA,90:GLACIER; leave empty for none): "))
+	if err != nil {
+		return nil, err
+	}
+
+	days := func(question string) (int64, error) {
+		answer := a.ask(question)
+		if answer == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(answer, 10, 64)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of days %q", answer)
+		}
+		return n, nil
+	}
+	if spec.expireDays, err = days("Expire objects after how many days? (leave empty for never): "); err != nil {
+		return nil, err
+	}
+	if spec.noncurrentExpireDays, err = days("Expire noncurrent versions after how many days? (leave empty for never): "); err != nil {
+		return nil, err
+	}
+	if spec.abortIncompleteDays, err = days("Abort incomplete multipart uploads after how many days? (leave empty for never): "); err != nil {
+		return nil, err
+	}
+	spec.disabled = a.ask("Enable the rule now? (yes/no): ") == "no"
+	return newLifecycleRule(spec)
+}
+
+func deleteSingleFileAction(a *app) {
+	fmt.Print("Enter file key: ")
+	fileKey, _ := a.reader.ReadString('\n')
+	fileKey = strings.TrimSpace(fileKey)
+	if a.confirmDeleteKeys(a.bucket, []string{fileKey}) != nil {
+		return
+	}
+	report(deleteSingleFile(a.client(), a.bucket, fileKey), "Error deleting file", "File deleted successfully.")
+}
+
+func deleteMultipleFilesAction(a *app) {
 	fmt.Print("Enter file keys (comma-separated): ")
-	fileKeys, _ := reader.ReadString('\n')
-	deleteMultipleFiles(svc, bucket, strings.TrimSpace(fileKeys))
+	fileKeys, _ := a.reader.ReadString('\n')
+	keys := splitList(fileKeys)
+	if a.confirmDeleteKeys(a.bucket, keys) != nil {
+		return
+	}
+	res, err := deleteMultipleFiles(a.client(), a.bucket, keys)
+	reportBatch(res, err, "Error deleting file", "%d files deleted successfully.")
 }
 
-func deleteFolderAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func deleteFolderAction(a *app) {
 	fmt.Print("Enter folder name: ")
-	folder, _ := reader.ReadString('\n')
-	deleteFolder(svc, bucket, strings.TrimSpace(folder))
+	folder, _ := a.reader.ReadString('\n')
+	folder = strings.TrimSpace(folder)
+	if a.confirmDeleteFolder(a.bucket, folder) != nil {
+		return
+	}
+	res, err := deleteFolder(a.client(), a.bucket, folder)
+	reportBatch(res, err, "Error deleting folder", "Folder deleted successfully (%d objects).")
 }
 
-func downloadSingleFileAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func downloadSingleFileAction(a *app) {
 	fmt.Print("Enter file key: ")
-	fileKey, _ := reader.ReadString('\n')
+	fileKey, _ := a.reader.ReadString('\n')
 	fmt.Print("Enter destination path: ")
-	destinationPath, _ := reader.ReadString('\n')
-	downloadSingleFile(svc, bucket, strings.TrimSpace(fileKey), strings.TrimSpace(destinationPath))
+	destinationPath, _ := a.reader.ReadString('\n')
+	progress := a.newProgress("Downloaded")
+	err := downloadSingleFile(a.client(), a.bucket, strings.TrimSpace(fileKey), strings.TrimSpace(destinationPath), a.opts.transfer, progress)
+	progress.stop()
+	report(err, "Error downloading file", "File downloaded successfully.")
 }
 
-func downloadMultipleFilesAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func downloadMultipleFilesAction(a *app) {
 	fmt.Print("Enter file keys and destination paths (comma-separated, key:path): ")
-	fileKeysAndPathsInput, _ := reader.ReadString('\n')
+	fileKeysAndPathsInput, _ := a.reader.ReadString('\n')
 	fileKeysAndPaths := make(map[string]string)
 	pairs := strings.Split(fileKeysAndPathsInput, ",")
 	for _, pair := range pairs {
@@ -156,152 +679,211 @@ func downloadMultipleFilesAction(svc *s3.S3, bucket string, reader *bufio.Reader
 			fileKeysAndPaths[keyAndPath[0]] = keyAndPath[1]
 		}
 	}
-	downloadMultipleFiles(svc, bucket, fileKeysAndPaths)
+	progress := a.newProgress("Downloaded")
+	res, err := downloadMultipleFiles(a.client(), a.bucket, fileKeysAndPaths, a.opts.transfer, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error downloading file", "%d files downloaded successfully.")
 }
 
-func listBucketsAndObjectsAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
-	listBucketsAndObjects(svc)
+func listBucketsAndObjectsAction(a *app) {
+	listings, err := listBucketsAndObjects(a.client())
+	if listings == nil && err != nil {
+		fmt.Println("Error listing buckets:", err)
+		return
+	}
+	printBucketListings(listings)
 }
 
-func getBucketInfoAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func getBucketInfoAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
-	getBucketInfo(svc, strings.TrimSpace(bucketName))
+	bucketName, _ := a.reader.ReadString('\n')
+	info, err := getBucketInfo(a.client(), strings.TrimSpace(bucketName))
+	if err != nil {
+		fmt.Println("Error getting bucket information:", err)
+		return
+	}
+	printBucketInfo(info)
 }
 
-func getObjectInfoAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func getObjectInfoAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
+	bucketName, _ := a.reader.ReadString('\n')
 	fmt.Print("Enter object key: ")
-	objectKey, _ := reader.ReadString('\n')
-	getObjectInfo(svc, strings.TrimSpace(bucketName), strings.TrimSpace(objectKey))
+	objectKey, _ := a.reader.ReadString('\n')
+	info, err := getObjectInfo(a.client(), strings.TrimSpace(bucketName), strings.TrimSpace(objectKey))
+	if err != nil {
+		fmt.Println("Error getting object information:", err)
+		return
+	}
+	printObjectInfo(info)
 }
 
-func setBucketPolicyAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func setBucketPolicyAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
+	bucketName, _ := a.reader.ReadString('\n')
 	fmt.Print("Enter policy JSON: ")
-	policy, _ := reader.ReadString('\n')
-	setBucketPolicy(svc, strings.TrimSpace(bucketName), strings.TrimSpace(policy))
+	policy, _ := a.reader.ReadString('\n')
+	err := setBucketPolicy(a.client(), strings.TrimSpace(bucketName), strings.TrimSpace(policy))
+	report(err, "Error setting bucket policy", "Bucket policy set successfully.")
 }
 
-func deleteBucketPolicyAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func deleteBucketPolicyAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
-	deleteBucketPolicy(svc, strings.TrimSpace(bucketName))
+	bucketName, _ := a.reader.ReadString('\n')
+	err := deleteBucketPolicy(a.client(), strings.TrimSpace(bucketName))
+	report(err, "Error deleting bucket policy", "Bucket policy deleted successfully.")
 }
 
-func setBucketACLAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func setBucketACLAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
+	bucketName, _ := a.reader.ReadString('\n')
 	fmt.Print("Enter ACL (e.g., private, public-read): ")
-	acl, _ := reader.ReadString('\n')
-	setBucketACL(svc, strings.TrimSpace(bucketName), strings.TrimSpace(acl))
+	acl, _ := a.reader.ReadString('\n')
+	err := setBucketACL(a.client(), strings.TrimSpace(bucketName), strings.TrimSpace(acl))
+	report(err, "Error setting bucket ACL", "Bucket ACL set successfully.")
 }
 
-func deleteBucketAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func deleteBucketAction(a *app) {
 	fmt.Print("Enter bucket name: ")
-	bucketName, _ := reader.ReadString('\n')
+	bucketName, _ := a.reader.ReadString('\n')
 	bucketName = strings.TrimSpace(bucketName)
-	region := *svc.Config.Region
-	deleteBucket(svc, region, bucketName)
+
+	fmt.Print("Empty the bucket first, deleting every object version and upload in it? (yes/no): ")
+	answer, _ := a.reader.ReadString('\n')
+	empty := strings.TrimSpace(strings.ToLower(answer)) == "yes"
+
+	if a.confirmDeleteBucket(bucketName, empty) != nil {
+		return
+	}
+	if empty && a.emptyBucket(bucketName) != nil {
+		return
+	}
+	report(deleteBucket(a.client(), bucketName), "Error deleting bucket", "Bucket deleted successfully.")
 }
 
-func setRegionAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+// emptyBucket removes every object version, delete marker and multipart
+// upload from bucket, showing a running count while it works.
+func (a *app) emptyBucket(bucket string) error {
+	res, err := emptyBucket(a.client(), bucket, a.opts.transfer.fileConcurrency, func(removed int) {
+		fmt.Printf("\rRemoved %d versions, delete markers and uploads", removed)
+	})
+	if len(res.succeeded) > 0 {
+		fmt.Println()
+	}
+	return reportBatch(res, err, "Error emptying bucket", "Bucket emptied: %d versions, delete markers and uploads removed.")
+}
+
+func setRegionAction(a *app) {
 	fmt.Print("Enter new AWS Region (e.g., eu-west-2): ")
-	newRegion, _ := reader.ReadString('\n')
+	newRegion, _ := a.reader.ReadString('\n')
 	newRegion = strings.TrimSpace(newRegion)
-	setRegion(svc, newRegion)
+	a.setRegion(newRegion)
+	fmt.Println("Region set successfully to:", newRegion)
 }
 
-func moveFilesAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func moveFilesAction(a *app) {
 	fmt.Print("Enter source folder: ")
-	sourceFolder, _ := reader.ReadString('\n')
+	sourceFolder, _ := a.reader.ReadString('\n')
 	sourceFolder = strings.TrimSpace(sourceFolder)
 
 	fmt.Print("Enter destination folder: ")
-	destinationFolder, _ := reader.ReadString('\n')
+	destinationFolder, _ := a.reader.ReadString('\n')
 	destinationFolder = strings.TrimSpace(destinationFolder)
 
 	fmt.Print("Enter file keys to move (comma-separated): ")
-	fileKeysInput, _ := reader.ReadString('\n')
-	fileKeys := strings.Split(strings.TrimSpace(fileKeysInput), ",")
-
-	for i, key := range fileKeys {
-		fileKeys[i] = strings.TrimSpace(key)
-	}
+	fileKeysInput, _ := a.reader.ReadString('\n')
+	fileKeys := splitList(fileKeysInput)
 
-	moveFiles(svc, bucket, sourceFolder, destinationFolder, fileKeys)
+	res, err := moveFiles(a.client(), a.bucket, sourceFolder, destinationFolder, fileKeys)
+	reportBatch(res, err, "Error moving file", fmt.Sprintf("%%d files moved successfully from %s to %s.", sourceFolder, destinationFolder))
 }
 
-func renameFileAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func renameFileAction(a *app) {
 	fmt.Print("Enter original file key: ")
-	originalKey, _ := reader.ReadString('\n')
+	originalKey, _ := a.reader.ReadString('\n')
 	originalKey = strings.TrimSpace(originalKey)
 
 	fmt.Print("Enter new file key: ")
-	newKey, _ := reader.ReadString('\n')
+	newKey, _ := a.reader.ReadString('\n')
 	newKey = strings.TrimSpace(newKey)
 
-	renameFile(svc, bucket, originalKey, newKey)
+	err := renameFile(a.client(), a.bucket, originalKey, newKey)
+	report(err, "Error renaming file", fmt.Sprintf("File %s renamed successfully to %s.", originalKey, newKey))
 }
 
-func moveFoldersAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func moveFoldersAction(a *app) {
 	fmt.Print("Enter source folders (comma-separated): ")
-	sourceFoldersInput, _ := reader.ReadString('\n')
-	sourceFolders := strings.Split(strings.TrimSpace(sourceFoldersInput), ",")
+	sourceFoldersInput, _ := a.reader.ReadString('\n')
+	sourceFolders := splitList(sourceFoldersInput)
 
 	fmt.Print("Enter destination folders (comma-separated): ")
-	destinationFoldersInput, _ := reader.ReadString('\n')
-	destinationFolders := strings.Split(strings.TrimSpace(destinationFoldersInput), ",")
+	destinationFoldersInput, _ := a.reader.ReadString('\n')
+	destinationFolders := splitList(destinationFoldersInput)
 
 	if len(sourceFolders) != len(destinationFolders) {
 		fmt.Println("Error: The number of source folders must match the number of destination folders.")
 		return
 	}
 
-	for i, folder := range sourceFolders {
-		sourceFolders[i] = strings.TrimSpace(folder)
-		destinationFolders[i] = strings.TrimSpace(destinationFolders[i])
-	}
-
-	moveFolders(svc, bucket, sourceFolders, destinationFolders)
+	progress := a.newProgress("Moved")
+	res, err := moveFolders(a.client(), a.bucket, sourceFolders, destinationFolders, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error moving object", "Folders moved successfully (%d objects).")
 }
 
-func renameFoldersAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func renameFoldersAction(a *app) {
 	fmt.Print("Enter original folder names (comma-separated): ")
-	originalFoldersInput, _ := reader.ReadString('\n')
-	originalFolders := strings.Split(strings.TrimSpace(originalFoldersInput), ",")
+	originalFoldersInput, _ := a.reader.ReadString('\n')
+	originalFolders := splitList(originalFoldersInput)
 
 	fmt.Print("Enter new folder names (comma-separated): ")
-	newFoldersInput, _ := reader.ReadString('\n')
-	newFolders := strings.Split(strings.TrimSpace(newFoldersInput), ",")
+	newFoldersInput, _ := a.reader.ReadString('\n')
+	newFolders := splitList(newFoldersInput)
 
 	if len(originalFolders) != len(newFolders) {
 		fmt.Println("Error: The number of original folders must match the number of new folder names.")
 		return
 	}
 
-	for i, folder := range originalFolders {
-		originalFolders[i] = strings.TrimSpace(folder)
-		newFolders[i] = strings.TrimSpace(newFolders[i])
-	}
-
-	renameFolders(svc, bucket, originalFolders, newFolders)
+	progress := a.newProgress("Moved")
+	res, err := renameFolders(a.client(), a.bucket, originalFolders, newFolders, progress)
+	progress.stop()
+	printTransferSummary(progress)
+	reportBatch(res, err, "Error renaming object", "Folders renamed successfully (%d objects).")
 }
 
-func generatePreSignedURLAction(svc *s3.S3, bucket string, reader *bufio.Reader) {
+func generatePreSignedURLAction(a *app) {
 	fmt.Print("Enter object name: ")
-	objectName, _ := reader.ReadString('\n')
+	objectName, _ := a.reader.ReadString('\n')
 	objectName = strings.TrimSpace(objectName)
 
 	fmt.Print("Enter pre-signed URL duration in minutes: ")
-	durationStr, _ := reader.ReadString('\n')
+	durationStr, _ := a.reader.ReadString('\n')
 	duration, err := strconv.ParseInt(strings.TrimSpace(durationStr), 10, 64)
 	if err != nil {
 		fmt.Println("Error parsing duration:", err)
 		return
 	}
 
-	generatePreSignedURL(svc, bucket, objectName, duration)
+	// tinyurl.com sees the URL, and with it access to the object.
+	answer := strings.ToLower(a.ask("Shorten with tinyurl? [y/N]: "))
+	shorten := answer == "y" || answer == "yes"
+	urlStr, err := presignURL(a.client(), a.bucket, objectName, duration, shorten)
+	if err != nil {
+		fmt.Println("Error generating pre-signed URL:", err)
+		return
+	}
+
+	fmt.Printf("Pre-signed URL for object %s: %s\n", objectName, urlStr)
+}
+
+// splitList splits a comma-separated answer into trimmed items.
+func splitList(input string) []string {
+	items := strings.Split(strings.TrimSpace(input), ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
 }